@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -10,19 +11,23 @@ import (
 )
 
 type showAllAchievementsModel struct {
-	events []achievements.HistoryEvent
+	engine *achievements.Engine
 	pages  [][]achievements.Achievement
 	page   int
+	cursor int // selected achievement on the page
+
+	// the achievement shown in detail, if any
+	detail *achievements.Achievement
 }
 
-func NewShowAllAchievementsModel(events []achievements.HistoryEvent) tea.Model {
+func NewShowAllAchievementsModel(engine *achievements.Engine) tea.Model {
 
 	var pages [][]achievements.Achievement
 	var page []achievements.Achievement
 
 	const perPage = 8
 
-	for _, a := range achievements.Achievements {
+	for _, a := range engine.Achievements() {
 		page = append(page, a)
 		if len(page) == perPage {
 			pages = append(pages, page)
@@ -34,7 +39,7 @@ func NewShowAllAchievementsModel(events []achievements.HistoryEvent) tea.Model {
 	}
 
 	return &showAllAchievementsModel{
-		events: events,
+		engine: engine,
 		pages:  pages,
 	}
 }
@@ -44,37 +49,67 @@ func (m *showAllAchievementsModel) Init() tea.Cmd {
 }
 
 func (m *showAllAchievementsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "down", "right", "tab", "n", "j", "l":
-			if m.page < len(m.pages)-1 {
-				m.page++
-			}
-		case "up", "left", "p", "h", "k":
-			if m.page > 0 {
-				m.page--
-			}
-		case "home":
-			m.page = 0
-		case "q", "esc", "enter":
-			return m, goToHomeCmd
+	key, ok := msg.(tea.KeyMsg)
+	if !ok {
+		return m, nil
+	}
+
+	if m.detail != nil {
+		switch key.String() {
+		case "q", "esc", "enter", "backspace":
+			m.detail = nil
 		}
+		return m, nil
+	}
+
+	switch key.String() {
+	case "down", "j", "tab":
+		if m.cursor < len(m.pages[m.page])-1 {
+			m.cursor++
+		} else if m.page < len(m.pages)-1 {
+			m.page++
+			m.cursor = 0
+		}
+	case "up", "k", "shift+tab":
+		if m.cursor > 0 {
+			m.cursor--
+		} else if m.page > 0 {
+			m.page--
+			m.cursor = len(m.pages[m.page]) - 1
+		}
+	case "right", "n", "l", "pgdown":
+		if m.page < len(m.pages)-1 {
+			m.page++
+			m.cursor = 0
+		}
+	case "left", "p", "h", "pgup":
+		if m.page > 0 {
+			m.page--
+			m.cursor = 0
+		}
+	case "home":
+		m.page = 0
+		m.cursor = 0
+	case "enter", " ":
+		a := m.pages[m.page][m.cursor]
+		m.detail = &a
+	case "q", "esc":
+		return m, goToHomeCmd
 	}
 	return m, nil
 }
 
 func (m *showAllAchievementsModel) View() string {
+	if m.detail != nil {
+		return m.detailView(*m.detail)
+	}
+
 	var showAchievements []string = []string{
 		listHeader("All Achievements"),
 	}
 
-	for _, a := range m.pages[m.page] {
-		if ok, _ := a.Func(m.events); ok {
-			showAchievements = append(showAchievements, listDone(a.Name))
-		} else {
-			showAchievements = append(showAchievements, listItem(a.Name))
-		}
+	for i, a := range m.pages[m.page] {
+		showAchievements = append(showAchievements, m.row(a, i == m.cursor))
 	}
 
 	// align unstructions with bottom
@@ -82,7 +117,92 @@ func (m *showAllAchievementsModel) View() string {
 		showAchievements = append(showAchievements, strings.Repeat("\n", 7-len(m.pages[m.page])))
 	}
 
-	showAchievements = append(showAchievements, lipgloss.NewStyle().Foreground(subtle).Render(fmt.Sprintf("Page %d/%d (n/p/q)", m.page+1, len(m.pages))))
+	showAchievements = append(showAchievements, lipgloss.NewStyle().Foreground(subtle).Render(fmt.Sprintf("Page %d/%d (↑/↓ n/p enter q)", m.page+1, len(m.pages))))
 
 	return deviceRightStyle.Copy().PaddingLeft(1).Render(lipgloss.JoinVertical(lipgloss.Left, showAchievements...))
 }
+
+// row shows an achievement in the list, with how far along it is if it has
+// to be done more than once
+func (m *showAllAchievementsModel) row(a achievements.Achievement, selected bool) string {
+	marker := " "
+	if selected {
+		marker = "›"
+	}
+
+	progress := m.engine.Progress(a)
+	if progress.Awarded() {
+		return marker + listDone(truncate(a.Name, 28))
+	}
+
+	name := a.Name
+	if progress.Target > 1 {
+		count := fmt.Sprintf("%d/%d", progress.Current(), progress.Target)
+		name = fmt.Sprintf("%-*s %s", 28-len(count), truncate(name, 28-len(count)), count)
+	}
+
+	style := lipgloss.NewStyle().PaddingLeft(1)
+	if selected {
+		style = style.Bold(true)
+	}
+	return marker + style.Render(name)
+}
+
+func (m *showAllAchievementsModel) detailView(a achievements.Achievement) string {
+	progress := m.engine.Progress(a)
+
+	lines := []string{
+		listHeader(a.Name),
+		inScreenStyle.Copy().Foreground(defaultText).Width(31).Render(a.Description),
+		"",
+	}
+
+	if progress.Awarded() {
+		lines = append(lines, lipgloss.NewStyle().Foreground(special).Render("✓ Awarded "+progress.AwardedAt.Format("2 Jan 2006")))
+	} else {
+		lines = append(lines, fmt.Sprintf("%s %d/%d", progressBar(progress, 16), progress.Current(), progress.Target))
+	}
+
+	lines = append(lines, fmt.Sprintf("Rarity: %s (%d XP)", a.Tier(), a.XP()))
+
+	if streak, ok := m.engine.Streak(a, time.Now()); ok {
+		lines = append(lines, fmt.Sprintf("Streak: %d %s (best %d)", streak.Current, streakUnit(streak), streak.Longest))
+	}
+
+	if a.Category != "" {
+		lines = append(lines, "Category: "+a.Category)
+	}
+	if len(a.Tags) > 0 {
+		lines = append(lines, "Tags: "+strings.Join(a.Tags, ", "))
+	}
+
+	lines = append(lines, "", lipgloss.NewStyle().Foreground(subtle).Render("(press enter to go back)"))
+
+	return deviceRightStyle.Copy().PaddingLeft(1).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// progressBar renders how far along the progress is, width characters wide
+func progressBar(p achievements.Progress, width int) string {
+	filled := 0
+	if p.Target > 0 {
+		filled = p.Current() * width / p.Target
+	}
+	return lipgloss.NewStyle().Foreground(special).Render(strings.Repeat("█", filled)) +
+		lipgloss.NewStyle().Foreground(subtle).Render(strings.Repeat("░", width-filled))
+}
+
+func streakUnit(s achievements.Streak) string {
+	if s.Period == achievements.Weekly {
+		return "weeks"
+	}
+	return "days"
+}
+
+// truncate shortens s to at most n characters, marking that it was cut
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -2,31 +2,74 @@ package achievements
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path"
+	"sort"
 	"time"
 
 	"github.com/sturdy-dev/marblezero/state"
 )
 
 type Achievement struct {
+	// ID identifies the achievement in the persisted state, and must never
+	// change once the achievement has been released. The name and description
+	// are only for display, and can be changed freely.
+	ID          string    `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	AwardedAt   time.Time `json:"awarded_at"`
 	Func        AchievementFunc
+
+	Category string   `json:"category,omitempty"` // e.g. "go" or "git", used to group achievements
+	Tags     []string `json:"tags,omitempty"`
+
+	// Rarity decides the XP the achievement gives, see Tier
+	Rarity Rarity `json:"rarity,omitempty"`
+
+	// Pack is the name of the pack the achievement was installed with, if any
+	Pack string `json:"pack,omitempty"`
+
+	// where the achievement was defined, if it was loaded from a file
+	file string
+	line int
+}
+
+// Key identifies the achievement in the persisted state. Achievements from
+// packs are namespaced by the pack, so that they can't collide with others.
+func (a Achievement) Key() string {
+	if a.Pack != "" {
+		return a.Pack + "/" + a.ID
+	}
+	return a.ID
 }
 
 type HistoryEvent struct {
-	Cmd string    `json:"cmd"`
-	At  time.Time `json:"at"`
+	Cmd   string    `json:"cmd"`
+	Typed string    `json:"typed,omitempty"` // the alias that was typed to run Cmd, if any
+	At    time.Time `json:"at"`
 
 	SubCommand     string   `json:"subcommand,omitempty"`      // only tracked for whitelisted commands
 	Flags          []string `json:"flags"`                     // only tracked for whitelisted commands
 	FileExtensions []string `json:"file_extensions,omitempty"` // tracked for all commands
+	Wrappers       []string `json:"wrappers,omitempty"`        // commands like sudo and xargs that ran Cmd, outermost first
+
+	// Set when the line contained several commands, such as pipelines and lists
+	Group    string `json:"group,omitempty"`
+	Operator string `json:"operator,omitempty"` // how the command is connected to the previous one in the group
+
+	// Outcome of the command, only known for events recorded by a post-exec hook
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Cwd      string        `json:"cwd,omitempty"`
+
+	// Set for events that were imported from a shell history file, like "zsh"
+	Source string `json:"source,omitempty"`
 
 	// Deprecated
 	IsForce bool `json:"is_force,omitempty"`
@@ -36,15 +79,85 @@ type HistoryEvent struct {
 
 type ConditionFunc func(HistoryEvent) bool
 
-type FilterFunc func([]HistoryEvent) []HistoryEvent
+// Progress is how far along an achievement is
+type Progress struct {
+	Count     int        `json:"count"`                // number of matching events
+	AwardedAt *time.Time `json:"awarded_at,omitempty"` // nil until awarded
+
+	// Target is the count the achievement is awarded at. It's part of the
+	// definition, so it isn't persisted.
+	Target int `json:"-"`
+}
+
+func (p Progress) Awarded() bool {
+	return p.AwardedAt != nil
+}
+
+// Current is the count towards the target, which stops at the target
+func (p Progress) Current() int {
+	if p.Count > p.Target {
+		return p.Target
+	}
+	return p.Count
+}
+
+// Evaluator tracks the progress of an achievement, one event at a time.
+// Events are added in the order they were recorded. The state of an evaluator
+// is persisted as JSON between runs, see Engine.
+type Evaluator interface {
+	Add(event HistoryEvent)
+	Progress() Progress
+}
+
+// AchievementFunc creates an evaluator for an achievement, without progress
+type AchievementFunc func() Evaluator
+
+// counter counts events matching a condition, and is awarded on the nth
+type counter struct {
+	condition ConditionFunc
+	n         int
+	progress  Progress
+}
+
+func (c *counter) Add(event HistoryEvent) {
+	if !c.condition(event) {
+		return
+	}
+	c.progress.Count++
+	if c.progress.Count == c.n && !c.progress.Awarded() {
+		at := event.At
+		c.progress.AwardedAt = &at
+	}
+}
+
+func (c *counter) Progress() Progress {
+	p := c.progress
+	p.Target = c.n
+	return p
+}
+
+func (c *counter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.progress)
+}
+
+func (c *counter) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &c.progress)
+}
+
+// always is awarded from the start
+type always struct{}
 
-type AchievementFunc func(events []HistoryEvent) (awarded bool, at *time.Time)
+func (always) Add(HistoryEvent) {}
+
+func (always) Progress() Progress {
+	return Progress{Count: 1, AwardedAt: &time.Time{}, Target: 1}
+}
 
 const achievementNameMaxLength = 29
 
 var (
-	trueFunc AchievementFunc = func(events []HistoryEvent) (bool, *time.Time) {
-		return true, &time.Time{}
+	trueFunc AchievementFunc = func() Evaluator {
+		return &always{}
 	}
 
 	withCommand = func(cmd string) ConditionFunc {
@@ -59,6 +172,17 @@ var (
 		}
 	}
 
+	withWrapper = func(wrapper string) ConditionFunc {
+		return func(event HistoryEvent) bool {
+			for _, w := range event.Wrappers {
+				if w == wrapper {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
 	withFlag = func(flag string) ConditionFunc {
 		return func(event HistoryEvent) bool {
 			for _, e := range event.Flags {
@@ -101,181 +225,276 @@ var (
 		}
 	}
 
-	times = func(n int) AchievementFunc {
-		return func(events []HistoryEvent) (bool, *time.Time) {
-			if len(events) < n {
-				return false, nil
-			}
-			t := events[n]
-			return true, &t.At
+	// the outcome of a command is only known when it was recorded by a
+	// post-exec hook, or imported from a history that keeps it
+
+	succeeded ConditionFunc = func(event HistoryEvent) bool {
+		return event.ExitCode != nil && *event.ExitCode == 0
+	}
+
+	failed ConditionFunc = func(event HistoryEvent) bool {
+		return event.ExitCode != nil && *event.ExitCode != 0
+	}
+
+	// withExitCode matches commands that exited with the code, like 127 for
+	// commands that weren't found and 130 for those interrupted with Ctrl-C
+	withExitCode = func(code int) ConditionFunc {
+		return func(event HistoryEvent) bool {
+			return event.ExitCode != nil && *event.ExitCode == code
+		}
+	}
+
+	withDurationOver = func(d time.Duration) ConditionFunc {
+		return func(event HistoryEvent) bool {
+			return event.Duration > d
 		}
 	}
 
-	and = func(filters ...ConditionFunc) FilterFunc {
-		return func(events []HistoryEvent) []HistoryEvent {
-			var res []HistoryEvent
-		loopEvents:
-			for _, e := range events {
-				for _, f := range filters {
-					if !f(e) {
-						continue loopEvents
-					}
+	// withDurationUnder only matches commands with a known outcome, as the
+	// duration of other commands isn't known either
+	withDurationUnder = func(d time.Duration) ConditionFunc {
+		return func(event HistoryEvent) bool {
+			return event.ExitCode != nil && event.Duration < d
+		}
+	}
+
+	and = func(conditions ...ConditionFunc) ConditionFunc {
+		return func(event HistoryEvent) bool {
+			for _, c := range conditions {
+				if !c(event) {
+					return false
 				}
-				res = append(res, e)
 			}
-			return res
+			return true
 		}
 	}
 
-	or = func(filters ...ConditionFunc) FilterFunc {
-		return func(events []HistoryEvent) []HistoryEvent {
-			var res []HistoryEvent
-		loopEvents:
-			for _, e := range events {
-				for _, f := range filters {
-					if f(e) {
-						res = append(res, e)
-					} else {
-						continue loopEvents
-					}
+	or = func(conditions ...ConditionFunc) ConditionFunc {
+		return func(event HistoryEvent) bool {
+			for _, c := range conditions {
+				if c(event) {
+					return true
 				}
 			}
-			return res
+			return false
 		}
 	}
 
-	first = func(filters FilterFunc) AchievementFunc {
-		return nth(filters, 0)
+	first = func(condition ConditionFunc) AchievementFunc {
+		return nth(condition, 1)
 	}
 
-	nth = func(filters FilterFunc, n int) AchievementFunc {
-		return func(events []HistoryEvent) (bool, *time.Time) {
-			filtered := filters(events)
-			if len(filtered) <= n {
-				return false, nil
-			}
-			return true, &filtered[n].At
+	// nth is awarded on the nth event matching the condition
+	nth = func(condition ConditionFunc, n int) AchievementFunc {
+		return func() Evaluator {
+			return &counter{condition: condition, n: n}
+		}
+	}
+
+	// sequence is awarded on the nth time the steps are matched in order
+	sequence = func(n int, steps ...Step) AchievementFunc {
+		return func() Evaluator {
+			return &sequenceCounter{steps: steps, n: n}
+		}
+	}
+
+	// then is a step that has to come right after the previous one
+	then = func(condition ConditionFunc) Step {
+		return Step{Condition: condition}
+	}
+
+	// within is a step that has to come within d of the previous one, with
+	// any number of events in between
+	within = func(condition ConditionFunc, d time.Duration) Step {
+		return Step{Condition: condition, Within: d, Gap: AnyGap}
+	}
+
+	// streak is awarded once the condition has been met n periods in a row
+	streak = func(condition ConditionFunc, period Period, n int) AchievementFunc {
+		return func() Evaluator {
+			return &streakCounter{condition: condition, period: period, n: n}
+		}
+	}
+
+	// distinct is awarded once events matching the condition had n distinct
+	// keys
+	distinct = func(condition ConditionFunc, keys func(HistoryEvent) []string, n int) AchievementFunc {
+		return func() Evaluator {
+			return &distinctCounter{condition: condition, keys: keys, n: n}
 		}
 	}
 
+	anyCommand ConditionFunc = func(HistoryEvent) bool { return true }
+
 	// Generally, the levels are awareded at 1, 50, 250, 1000 times
 
 	anyPython = or(withCommand("python2"), withCommand("python3"), withCommand("python"))
 	anyNpm    = or(withCommand("npm"), withCommand("yarn"), withCommand("pnpm"))
 	anyJava   = or(withCommand("javac"), withCommand("gradlew"), withCommand("gradle"), withCommand("mvn"))
+	anyBuild  = or(withSubCommand("go", "build"), withSubCommand("cargo", "build"), withSubCommand("bazel", "build"), withSubCommand("docker", "build"),
+		withSubCommand("swift", "build"), withSubCommand("dotnet", "build"), withCommand("make"), withCommand("mvn"), withCommand("gradle"), withCommand("gradlew"))
+	anyEditor = or(withCommand("vim"), withCommand("nvim"), withCommand("emacs"), withCommand("nano"), withCommand("code"), withCommand("hx"))
+	anyTest   = or(withSubCommand("go", "test"), withSubCommand("cargo", "test"), withSubCommand("bazel", "test"), withSubCommand("npm", "test"),
+		withSubCommand("yarn", "test"), withSubCommand("pnpm", "test"), withSubCommand("dotnet", "test"), withCommand("pytest"), withCommand("jest"))
 
 	Achievements = []Achievement{
-		{Name: "Name your pet", Func: trueFunc},
+		{ID: "name-your-pet", Category: "meta", Name: "Name your pet", Func: trueFunc},
 
 		// Deno
-		{Name: "node << 2", Description: "Use deno", Func: first(and(withCommand("deno")))},
+		{ID: "deno", Category: "javascript", Tags: []string{"language"}, Name: "node << 2", Description: "Use deno", Func: first(and(withCommand("deno")))},
 
 		// Node
-		{Name: "npm i left-pad", Description: "Install a npm package", Func: first(anyNpm)},
+		{ID: "npm", Category: "javascript", Tags: []string{"language", "packages"}, Name: "npm i left-pad", Description: "Install a npm package", Func: first(anyNpm)},
 
 		// Go
-		{Name: "Gopher", Description: "Use Go", Func: first(and(withCommand("go")))},
-		{Name: "Go-go-gadget!", Description: "Use Go 50 times", Func: nth(and(withCommand("go")), 50)},
-		{Name: "if err != nil", Description: "Use Go 250 times", Func: nth(and(withCommand("go")), 250)},
-		{Name: "I love Rob", Description: "Use Go 1000 times", Func: nth(and(withCommand("go")), 1000)},
+		{ID: "go", Category: "go", Tags: []string{"language"}, Name: "Gopher", Description: "Use Go", Func: first(and(withCommand("go")))},
+		{ID: "go-50", Category: "go", Tags: []string{"language", "milestone"}, Name: "Go-go-gadget!", Description: "Use Go 50 times", Func: nth(and(withCommand("go")), 50)},
+		{ID: "go-250", Category: "go", Tags: []string{"language", "milestone"}, Name: "if err != nil", Description: "Use Go 250 times", Func: nth(and(withCommand("go")), 250)},
+		{ID: "go-1000", Category: "go", Tags: []string{"language", "milestone"}, Name: "I love Rob", Description: "Use Go 1000 times", Func: nth(and(withCommand("go")), 1000)},
 
 		// Rust
-		{Name: "Getting Rusty", Description: "Use Cargo", Func: first(or(withCommand("cargo"), withCommand("rustc")))},
-		{Name: "No bugs to be seen here", Description: "Use Cargo 50 times", Func: nth(or(withCommand("cargo"), withCommand("rustc")), 50)},
-		{Name: "Rewrite it in Rust", Description: "Use Cargo 250 times", Func: nth(or(withCommand("cargo"), withCommand("rustc")), 250)},
-		{Name: "Zero-cost abstracter", Description: "Use Cargo 1000 times", Func: nth(or(withCommand("cargo"), withCommand("rustc")), 1000)},
+		{ID: "cargo", Category: "rust", Tags: []string{"language"}, Name: "Getting Rusty", Description: "Use Cargo", Func: first(or(withCommand("cargo"), withCommand("rustc")))},
+		{ID: "cargo-50", Category: "rust", Tags: []string{"language", "milestone"}, Name: "No bugs to be seen here", Description: "Use Cargo 50 times", Func: nth(or(withCommand("cargo"), withCommand("rustc")), 50)},
+		{ID: "cargo-250", Category: "rust", Tags: []string{"language", "milestone"}, Name: "Rewrite it in Rust", Description: "Use Cargo 250 times", Func: nth(or(withCommand("cargo"), withCommand("rustc")), 250)},
+		{ID: "cargo-1000", Category: "rust", Tags: []string{"language", "milestone"}, Name: "Zero-cost abstracter", Description: "Use Cargo 1000 times", Func: nth(or(withCommand("cargo"), withCommand("rustc")), 1000)},
 
 		// Python
-		{Name: "Import from __legacy__", Description: "Use Python2", Func: first(and(withCommand("python2")))},
-		{Name: "Early adopter", Description: "Use Python3", Func: first(and(withCommand("python3")))},
-		{Name: "Psuedocoder", Description: "Use Python", Func: nth(anyPython, 1)},
-		{Name: "Master of indentation", Description: "Use Python 50 times", Func: nth(anyPython, 50)},
-		{Name: "Pythonista", Description: "Use Python 250 times", Func: nth(anyPython, 250)},
-		{Name: "Parseltongue", Description: "Use Python 1000 times", Func: nth(anyPython, 1000)},
+		{ID: "python2", Category: "python", Tags: []string{"language"}, Name: "Import from __legacy__", Description: "Use Python2", Func: first(and(withCommand("python2")))},
+		{ID: "python3", Category: "python", Tags: []string{"language"}, Name: "Early adopter", Description: "Use Python3", Func: first(and(withCommand("python3")))},
+		{ID: "python", Category: "python", Tags: []string{"language"}, Name: "Pseudocoder", Description: "Use Python", Func: nth(anyPython, 1)},
+		{ID: "python-50", Category: "python", Tags: []string{"language", "milestone"}, Name: "Master of indentation", Description: "Use Python 50 times", Func: nth(anyPython, 50)},
+		{ID: "python-250", Category: "python", Tags: []string{"language", "milestone"}, Name: "Pythonista", Description: "Use Python 250 times", Func: nth(anyPython, 250)},
+		{ID: "python-1000", Category: "python", Tags: []string{"language", "milestone"}, Name: "Parseltongue", Description: "Use Python 1000 times", Func: nth(anyPython, 1000)},
 
 		// Git
-		{Name: "Teamwork makes the dream work", Description: "Use git", Func: first(and(withCommand("git")))},
-		{Name: "Oncaller", Description: "Make a git commit in the middle of the night", Func: first(and(withSubCommand("git", "commit"), withHourRange(2, 5)))},
-		{Name: "Use the --force", Description: "Use a git command with --force", Func: first(and(withCommand("git"), func(e HistoryEvent) bool { return e.IsForce }))},
+		{ID: "git", Category: "git", Tags: []string{"vcs"}, Name: "Teamwork makes the dream work", Description: "Use git", Func: first(and(withCommand("git")))},
+		{ID: "git-commit-at-night", Category: "git", Tags: []string{"vcs", "time"}, Name: "Oncaller", Description: "Make a git commit in the middle of the night", Func: first(and(withSubCommand("git", "commit"), withHourRange(2, 5)))},
+		{ID: "git-force", Category: "git", Tags: []string{"vcs", "danger"}, Name: "Use the --force", Description: "Use a git command with --force", Func: first(and(withCommand("git"), func(e HistoryEvent) bool { return e.IsForce }))},
+		{ID: "git-force-push-reflog", Category: "git", Tags: []string{"vcs", "danger", "sequence"}, Rarity: Rare, Name: "What have I done", Description: "Run git reflog right after a force push", Func: sequence(1, then(and(withSubCommand("git", "push"), or(withFlag("--force"), withFlag("-f")))), then(withSubCommand("git", "reflog")))},
 
 		// Git commit streaks
-		{Name: "Contributor", Description: "Make a git commit", Func: first(and(withSubCommand("git", "commit")))},
-		{Name: "Developer", Description: "Make 50 git commits", Func: nth(and(withSubCommand("git", "commit")), 50)},
-		{Name: "Coder", Description: "Make 250 git commits", Func: nth(and(withSubCommand("git", "commit")), 250)},
-		{Name: "10xer", Description: "Make 1000 git commits", Func: nth(and(withSubCommand("git", "commit")), 1000)},
+		{ID: "git-commit", Category: "git", Tags: []string{"vcs"}, Name: "Contributor", Description: "Make a git commit", Func: first(and(withSubCommand("git", "commit")))},
+		{ID: "git-commit-50", Category: "git", Tags: []string{"vcs", "milestone"}, Name: "Developer", Description: "Make 50 git commits", Func: nth(and(withSubCommand("git", "commit")), 50)},
+		{ID: "git-commit-250", Category: "git", Tags: []string{"vcs", "milestone"}, Name: "Coder", Description: "Make 250 git commits", Func: nth(and(withSubCommand("git", "commit")), 250)},
+		{ID: "git-commit-1000", Category: "git", Tags: []string{"vcs", "milestone"}, Name: "10xer", Description: "Make 1000 git commits", Func: nth(and(withSubCommand("git", "commit")), 1000)},
 
 		// Java
-		{Name: "A cup of coffee", Description: "Use java", Func: first(anyJava)},
-		{Name: "JavaFactoryManagerBuilder", Description: "Use java 50 times", Func: nth(anyJava, 50)},
-		{Name: "OO > OOMs", Description: "Use java 250 times", Func: nth(anyJava, 250)},
-		{Name: "Indonesian native", Description: "Use java 1000 times", Func: nth(anyJava, 1000)},
+		{ID: "java", Category: "java", Tags: []string{"language"}, Name: "A cup of coffee", Description: "Use java", Func: first(anyJava)},
+		{ID: "java-50", Category: "java", Tags: []string{"language", "milestone"}, Name: "JavaFactoryManagerBuilder", Description: "Use java 50 times", Func: nth(anyJava, 50)},
+		{ID: "java-250", Category: "java", Tags: []string{"language", "milestone"}, Name: "OO > OOMs", Description: "Use java 250 times", Func: nth(anyJava, 250)},
+		{ID: "java-1000", Category: "java", Tags: []string{"language", "milestone"}, Name: "Indonesian native", Description: "Use java 1000 times", Func: nth(anyJava, 1000)},
 
 		// Bazel
-		{Name: "Fast and Correct", Description: "Use Bazel", Func: first(and(withCommand("bazel")))},
-		{Name: "Chosing both", Description: "Use Bazel 50 times", Func: nth(and(withCommand("bazel")), 50)},
-		{Name: "Airtight", Description: "Use Bazel 250 times", Func: nth(and(withCommand("bazel")), 250)},
-		{Name: "No escaping the jail", Description: "Use Bazel 1000 times", Func: nth(and(withCommand("bazel")), 1000)},
+		{ID: "bazel", Category: "bazel", Tags: []string{"build"}, Name: "Fast and Correct", Description: "Use Bazel", Func: first(and(withCommand("bazel")))},
+		{ID: "bazel-50", Category: "bazel", Tags: []string{"build", "milestone"}, Name: "Choosing both", Description: "Use Bazel 50 times", Func: nth(and(withCommand("bazel")), 50)},
+		{ID: "bazel-250", Category: "bazel", Tags: []string{"build", "milestone"}, Name: "Airtight", Description: "Use Bazel 250 times", Func: nth(and(withCommand("bazel")), 250)},
+		{ID: "bazel-1000", Category: "bazel", Tags: []string{"build", "milestone"}, Name: "No escaping the jail", Description: "Use Bazel 1000 times", Func: nth(and(withCommand("bazel")), 1000)},
 
 		// pushd/popd
-		{Name: "Power navigator", Description: "Use popd or pushd", Func: first(or(withCommand("pushd"), withCommand("popd")))},
+		{ID: "pushd-popd", Category: "shell", Tags: []string{"navigation"}, Name: "Power navigator", Description: "Use popd or pushd", Func: first(or(withCommand("pushd"), withCommand("popd")))},
 
 		// Downloads
-		{Name: "Curlious", Description: "Use curl", Func: first(and(withCommand("curl")))},
-		{Name: "Get it?", Description: "Use wget", Func: first(and(withCommand("wget")))},
-		{Name: "Safety first", Description: "Use sha25sum", Func: first(and(withCommand("sha256sum")))},
+		{ID: "curl", Category: "downloads", Tags: []string{"network"}, Name: "Curlious", Description: "Use curl", Func: first(and(withCommand("curl")))},
+		{ID: "wget", Category: "downloads", Tags: []string{"network"}, Name: "Get it?", Description: "Use wget", Func: first(and(withCommand("wget")))},
+		{ID: "sha256sum", Category: "downloads", Tags: []string{"security"}, Name: "Safety first", Description: "Use sha256sum", Func: first(and(withCommand("sha256sum")))},
 
 		// Polyglot
-		{Name: "Polyglot", Description: "Add 3 files with different extensions to the git staging area", Func: first(and(withSubCommand("git", "add"), withUniqueFileExtsMin(3)))},
-		{Name: "International Spy", Description: "Add 3 files with different extensions to the git staging area, 50 times", Func: nth(and(withSubCommand("git", "add"), withUniqueFileExtsMin(3)), 50)},
+		{ID: "git-add-polyglot", Category: "git", Tags: []string{"vcs", "files"}, Name: "Polyglot", Description: "Add 3 files with different extensions to the git staging area", Func: first(and(withSubCommand("git", "add"), withUniqueFileExtsMin(3)))},
+		{ID: "git-add-polyglot-50", Category: "git", Tags: []string{"vcs", "files", "milestone"}, Name: "International Spy", Description: "Add 3 files with different extensions to the git staging area, 50 times", Func: nth(and(withSubCommand("git", "add"), withUniqueFileExtsMin(3)), 50)},
 
 		// Editors
-		{Name: "How do I exit this thing?", Description: "Edit a file with vim", Func: first(and(withCommand("vim")))},
-		{Name: "M-x give-me-achievement", Description: "Edit a file with emacs", Func: first(and(withCommand("emacs")))},
-		{Name: "Keeping it simple", Description: "Edit a file with nano", Func: first(and(withCommand("nano")))},
+		{ID: "vim", Category: "editors", Tags: []string{"editor"}, Name: "How do I exit this thing?", Description: "Edit a file with vim", Func: first(and(withCommand("vim")))},
+		{ID: "emacs", Category: "editors", Tags: []string{"editor"}, Name: "M-x give-me-achievement", Description: "Edit a file with emacs", Func: first(and(withCommand("emacs")))},
+		{ID: "nano", Category: "editors", Tags: []string{"editor"}, Name: "Keeping it simple", Description: "Edit a file with nano", Func: first(and(withCommand("nano")))},
+		{ID: "vim-rage-quit", Category: "editors", Tags: []string{"editor", "sequence"}, Rarity: Rare, Name: "Rage quit", Description: "Leave vim within five seconds of opening it", Func: sequence(1, then(withCommand("vim")), within(anyCommand, 5*time.Second))},
 
 		// Shells
-		{Name: "Show 'em whos boss", Description: "Use sudo", Func: first(and(withCommand("sudo")))},
-		{Name: "Back to the past", Description: "Use sh", Func: first(and(withCommand("sh")))},
-		{Name: "Gone fishin' 🐟", Description: "Use fish", Func: first(and(withCommand("fish")))}, // Alternative title: "90s kid"
+		{ID: "sudo", Category: "shell", Tags: []string{"danger"}, Name: "Show 'em whos boss", Description: "Use sudo", Func: first(or(withCommand("sudo"), withWrapper("sudo")))},
+		{ID: "sh", Category: "shell", Name: "Back to the past", Description: "Use sh", Func: first(and(withCommand("sh")))},
+		{ID: "fish", Category: "shell", Name: "Gone fishin' 🐟", Description: "Use fish", Func: first(and(withCommand("fish")))}, // Alternative title: "90s kid"
 
-		{Name: "Early bird", Description: "Use a command bewtween 05:00 and 07:00", Func: first(and(withHourRange(5, 7)))},
-		{Name: "I love my cubicle", Description: "Use a command bewtween 07:00 and 17:00", Func: first(and(withHourRange(9, 17)))},
-		{Name: "Night owl", Description: "Use a command bewtween 01:00 and 03:00", Func: first(and(withHourRange(1, 3)))},
+		{ID: "early-bird", Category: "time", Tags: []string{"time"}, Name: "Early bird", Description: "Use a command between 05:00 and 07:00", Func: first(and(withHourRange(5, 7)))},
+		{ID: "office-hours", Category: "time", Tags: []string{"time"}, Name: "I love my cubicle", Description: "Use a command between 09:00 and 17:00", Func: first(and(withHourRange(9, 17)))},
+		{ID: "night-owl", Category: "time", Tags: []string{"time"}, Name: "Night owl", Description: "Use a command between 01:00 and 03:00", Func: first(and(withHourRange(1, 3)))},
 
-		{Name: "Local Google", Description: "Use fzf", Func: first(and(withCommand("fzf")))},
-		{Name: "No backsies", Description: "Delete a directory with rm -rf", Func: first(and(withCommand("rm"), func(e HistoryEvent) bool { return e.IsRmRf }))},
+		{ID: "fzf", Category: "misc", Tags: []string{"search"}, Name: "Local Google", Description: "Use fzf", Func: first(and(withCommand("fzf")))},
+		{ID: "rm-rf", Category: "shell", Tags: []string{"danger"}, Name: "No backsies", Description: "Delete a directory with rm -rf", Func: first(and(withCommand("rm"), func(e HistoryEvent) bool { return e.IsRmRf }))},
 
 		// Docker
-		{Name: "Reproducable Whales", Description: "Use docker", Func: first(and(withCommand("docker")))},
-		{Name: "Works on my machine", Description: "Use docker 50 times", Func: nth(and(withCommand("docker")), 50)},
-		{Name: "I ❤️ :latest", Description: "Use docker 250 times", Func: nth(and(withCommand("docker")), 250)},
-		{Name: "Testing in production", Description: "Use docker 1000 times", Func: nth(and(withCommand("docker")), 1000)},
+		{ID: "docker", Category: "containers", Tags: []string{"containers"}, Name: "Reproducible Whales", Description: "Use docker", Func: first(and(withCommand("docker")))},
+		{ID: "docker-50", Category: "containers", Tags: []string{"containers", "milestone"}, Name: "Works on my machine", Description: "Use docker 50 times", Func: nth(and(withCommand("docker")), 50)},
+		{ID: "docker-250", Category: "containers", Tags: []string{"containers", "milestone"}, Name: "I ❤️ :latest", Description: "Use docker 250 times", Func: nth(and(withCommand("docker")), 250)},
+		{ID: "docker-1000", Category: "containers", Tags: []string{"containers", "milestone"}, Name: "Testing in production", Description: "Use docker 1000 times", Func: nth(and(withCommand("docker")), 1000)},
 
 		// Kubernetes
-		{Name: "Kubernaught", Description: "Use kubectl", Func: first(and(withCommand("kubectl")))},
-		{Name: "YAML-engineer", Description: "Use kubectl 50 times", Func: nth(and(withCommand("kubectl")), 50)},
-		{Name: "The cloud is my computer", Description: "Use kubectl 250 times", Func: nth(and(withCommand("kubectl")), 250)},
-		{Name: "Cloud Native", Description: "Use kubectl 1000 times", Func: nth(and(withCommand("kubectl")), 1000)},
+		{ID: "kubectl", Category: "kubernetes", Tags: []string{"containers", "cloud"}, Name: "Kubernaught", Description: "Use kubectl", Func: first(and(withCommand("kubectl")))},
+		{ID: "kubectl-50", Category: "kubernetes", Tags: []string{"containers", "cloud", "milestone"}, Name: "YAML-engineer", Description: "Use kubectl 50 times", Func: nth(and(withCommand("kubectl")), 50)},
+		{ID: "kubectl-250", Category: "kubernetes", Tags: []string{"containers", "cloud", "milestone"}, Name: "The cloud is my computer", Description: "Use kubectl 250 times", Func: nth(and(withCommand("kubectl")), 250)},
+		{ID: "kubectl-1000", Category: "kubernetes", Tags: []string{"containers", "cloud", "milestone"}, Name: "Cloud Native", Description: "Use kubectl 1000 times", Func: nth(and(withCommand("kubectl")), 1000)},
 
 		// Misc commands and programs
-		{Name: "Homemade 🍺", Description: "Use brew", Func: first(and(withCommand("brew")))},
-		{Name: "SELECT FROM json", Description: "Use jq", Func: first(and(withCommand("jq")))},
-		{Name: "Beam me up", Description: "Use ssh", Func: first(and(withCommand("ssh")))},
-		{Name: "Archivist", Description: "Use tar", Func: first(and(withCommand("tar")))},
-		{Name: "Stack Overflow", Description: "Use pbcopy", Func: first(and(withCommand("pbcopy")))},
-		{Name: "You know you're screwed when", Description: "Use xcode-select --install, for the second time", Func: nth(and(withCommand("xcode-select"), withFlag("--install")), 2)},
-		{Name: "Found Waldo", Description: "Use grep", Func: first(or(withCommand("grep"), withCommand("rg")))},
+		{ID: "brew", Category: "misc", Tags: []string{"packages"}, Name: "Homemade 🍺", Description: "Use brew", Func: first(and(withCommand("brew")))},
+		{ID: "jq", Category: "misc", Tags: []string{"data"}, Name: "SELECT FROM json", Description: "Use jq", Func: first(and(withCommand("jq")))},
+		{ID: "ssh", Category: "misc", Tags: []string{"network"}, Name: "Beam me up", Description: "Use ssh", Func: first(and(withCommand("ssh")))},
+		{ID: "tar", Category: "misc", Tags: []string{"files"}, Name: "Archivist", Description: "Use tar", Func: first(and(withCommand("tar")))},
+		{ID: "pbcopy", Category: "misc", Name: "Stack Overflow", Description: "Use pbcopy", Func: first(and(withCommand("pbcopy")))},
+		{ID: "xcode-select-install-twice", Category: "misc", Rarity: Rare, Name: "You know you're screwed when", Description: "Use xcode-select --install, for the second time", Func: nth(and(withCommand("xcode-select"), withFlag("--install")), 2)},
+		{ID: "grep", Category: "misc", Tags: []string{"search"}, Name: "Found Waldo", Description: "Use grep", Func: first(or(withCommand("grep"), withCommand("rg")))},
+
+		// Outcomes, only known for commands recorded by the shell integration
+		{ID: "command-not-found", Category: "outcomes", Tags: []string{"failure"}, Name: "Command not found", Description: "Run a command that doesn't exist", Func: first(withExitCode(127))},
+		{ID: "ctrl-c", Category: "outcomes", Tags: []string{"failure"}, Name: "Abort mission", Description: "Stop a command with Ctrl-C", Func: first(withExitCode(130))},
+		{ID: "ctrl-c-50", Category: "outcomes", Tags: []string{"failure", "milestone"}, Name: "Impatient", Description: "Stop 50 commands with Ctrl-C", Func: nth(withExitCode(130), 50)},
+		{ID: "failed-100", Category: "outcomes", Tags: []string{"failure", "milestone"}, Name: "Learning experience", Description: "Have 100 commands fail", Func: nth(failed, 100)},
+		{ID: "build-5m", Category: "outcomes", Tags: []string{"build", "duration"}, Name: "Compile time is coffee time", Description: "Wait over 5 minutes for a build", Func: first(and(anyBuild, withDurationOver(5*time.Minute)))},
+		{ID: "build-1h", Category: "outcomes", Tags: []string{"build", "duration"}, Rarity: Epic, Name: "Go home, compiler", Description: "Wait over an hour for a build", Func: first(and(anyBuild, withDurationOver(time.Hour)))},
+		{ID: "marathon", Category: "outcomes", Tags: []string{"duration"}, Rarity: Epic, Name: "Marathon", Description: "Run a command for over 8 hours", Func: first(withDurationOver(8 * time.Hour))},
+		{ID: "fixed-it", Category: "outcomes", Tags: []string{"build", "sequence"}, Rarity: Rare, Name: "Fixed it", Description: "Get a build passing after three failed attempts", Func: sequence(1,
+			then(and(anyBuild, failed)), within(and(anyBuild, failed), time.Hour), within(and(anyBuild, failed), time.Hour), within(and(anyBuild, succeeded), time.Hour))},
+		{ID: "tests-green", Category: "outcomes", Tags: []string{"tests", "sequence"}, Rarity: Rare, Name: "All green", Description: "Get failing tests to pass", Func: sequence(1, then(and(anyTest, failed)), within(and(anyTest, succeeded), time.Hour))},
+
+		// Streaks
+		{ID: "daily-7", Category: "streaks", Tags: []string{"streak"}, Rarity: Rare, Name: "Creature of habit", Description: "Use the terminal 7 days in a row", Func: streak(anyCommand, Daily, 7)},
+		{ID: "daily-30", Category: "streaks", Tags: []string{"streak", "milestone"}, Rarity: Epic, Name: "No days off", Description: "Use the terminal 30 days in a row", Func: streak(anyCommand, Daily, 30)},
+		{ID: "git-commit-weekdays-20", Category: "streaks", Tags: []string{"streak", "vcs"}, Rarity: Epic, Name: "Clockwork committer", Description: "Commit every weekday for 4 weeks", Func: streak(withSubCommand("git", "commit"), Weekdays, 20)},
+		{ID: "git-push-weekly-8", Category: "streaks", Tags: []string{"streak", "vcs"}, Rarity: Epic, Name: "Weekly shipper", Description: "Push every week for 8 weeks", Func: streak(withSubCommand("git", "push"), Weekly, 8)},
 
 		// Meta
-		{Name: "Caretaker", Description: "Launch Marble Zero 10 times", Func: nth(and(withCommand("marblezero")), 10)},
+		{ID: "marblezero-10", Category: "meta", Tags: []string{"milestone"}, Name: "Caretaker", Description: "Launch Marble Zero 10 times", Func: nth(and(withCommand("marblezero")), 10)},
 
 		// ls
 		// htop
 	}
 )
 
+// CorruptLine is a line of the wal that could not be parsed
+type CorruptLine struct {
+	Line int // 1-based
+	Err  error
+}
+
+// CorruptError is returned by ParseHistory together with the events that
+// could be parsed, if some lines of the wal are corrupt. It's safe to keep
+// going with the events.
+type CorruptError struct {
+	Lines []CorruptLine
+}
+
+func (e *CorruptError) Error() string {
+	first := e.Lines[0]
+	if len(e.Lines) == 1 {
+		return fmt.Sprintf("corrupt line in wal, line %d: %v", first.Line, first.Err)
+	}
+	return fmt.Sprintf("%d corrupt lines in wal, first on line %d: %v", len(e.Lines), first.Line, first.Err)
+}
+
+// ParseHistory reads the events in the wal. If some lines are corrupt, the
+// events that could be read are returned with a *CorruptError.
+//
+// Events that have been compacted are not in the wal, see Engine.
 func ParseHistory(storagePath state.StoragePath) ([]HistoryEvent, error) {
-	file, err := os.Open(path.Join(string(storagePath), "history_wal"))
+	// don't read while a command is being written
+	file, err := state.OpenLocked(walPath(storagePath), os.O_RDONLY, 0, false)
 	if errors.Is(err, os.ErrNotExist) {
 		return []HistoryEvent{}, nil
 	} else if err != nil {
@@ -283,21 +502,63 @@ func ParseHistory(storagePath state.StoragePath) ([]HistoryEvent, error) {
 	}
 	defer file.Close()
 
+	events, corrupt, err := readEvents(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(corrupt) > 0 {
+		return events, &CorruptError{Lines: corrupt}
+	}
+
+	return events, nil
+}
+
+func walPath(storagePath state.StoragePath) string {
+	return path.Join(string(storagePath), "history_wal")
+}
+
+// readEvents reads events from the wal, sorted by when they happened
+func readEvents(r io.Reader) ([]HistoryEvent, []CorruptLine, error) {
 	var events []HistoryEvent
+	var corrupt []CorruptLine
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
 		var e HistoryEvent
 		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			corrupt = append(corrupt, CorruptLine{Line: line, Err: err})
 			continue
 		}
 		events = append(events, e)
 	}
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to scan wal: %w", err)
+		return nil, nil, fmt.Errorf("failed to scan wal: %w", err)
 	}
 
-	return events, nil
+	// Imported history is appended after newer events
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].At.Before(events[b].At)
+	})
+
+	return events, corrupt, nil
+}
+
+// Lines groups consecutive events that were run on the same command line.
+func Lines(events []HistoryEvent) [][]HistoryEvent {
+	var lines [][]HistoryEvent
+	for i, e := range events {
+		if i > 0 && e.Group != "" && e.Group == events[i-1].Group {
+			lines[len(lines)-1] = append(lines[len(lines)-1], e)
+			continue
+		}
+		lines = append(lines, []HistoryEvent{e})
+	}
+	return lines
 }
 
 func init() {
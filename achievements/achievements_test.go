@@ -0,0 +1,106 @@
+package achievements
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOr(t *testing.T) {
+	rust := or(withCommand("cargo"), withCommand("rustc"))
+	sudo := or(withCommand("sudo"), withWrapper("sudo"))
+
+	cases := []struct {
+		name      string
+		condition ConditionFunc
+		event     HistoryEvent
+		expected  bool
+	}{
+		{name: "first condition", condition: rust, event: HistoryEvent{Cmd: "cargo"}, expected: true},
+		{name: "second condition", condition: rust, event: HistoryEvent{Cmd: "rustc"}, expected: true},
+		{name: "no condition", condition: rust, event: HistoryEvent{Cmd: "go"}, expected: false},
+		{name: "sudo", condition: sudo, event: HistoryEvent{Cmd: "sudo"}, expected: true},
+		{name: "wrapped by sudo", condition: sudo, event: HistoryEvent{Cmd: "apt", Wrappers: []string{"sudo"}}, expected: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.condition(tc.event))
+		})
+	}
+}
+
+func TestNth(t *testing.T) {
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	var events []HistoryEvent
+	for i := 0; i < 5; i++ {
+		events = append(events, HistoryEvent{Cmd: "git", At: start.Add(time.Duration(i) * time.Minute)})
+	}
+
+	// n counts from 1, the third event awards nth(condition, 3)
+	evaluator := nth(withCommand("git"), 3)()
+	for _, e := range events {
+		evaluator.Add(e)
+	}
+	progress := evaluator.Progress()
+	assert.Equal(t, 5, progress.Count)
+	require.True(t, progress.Awarded())
+	assert.Equal(t, start.Add(2*time.Minute), *progress.AwardedAt)
+
+	evaluator = first(withCommand("git"))()
+	evaluator.Add(events[0])
+	require.True(t, evaluator.Progress().Awarded())
+	assert.Equal(t, start, *evaluator.Progress().AwardedAt)
+}
+
+func TestOutcomeConditions(t *testing.T) {
+	code := func(c int) *int { return &c }
+
+	cases := []struct {
+		name      string
+		condition ConditionFunc
+		event     HistoryEvent
+		want      bool
+	}{
+		{"succeeded", succeeded, HistoryEvent{ExitCode: code(0)}, true},
+		{"succeeded failed", succeeded, HistoryEvent{ExitCode: code(1)}, false},
+		{"succeeded unknown", succeeded, HistoryEvent{}, false},
+		{"failed", failed, HistoryEvent{ExitCode: code(2)}, true},
+		{"failed unknown", failed, HistoryEvent{}, false},
+		{"exit code", withExitCode(127), HistoryEvent{ExitCode: code(127)}, true},
+		{"other exit code", withExitCode(130), HistoryEvent{ExitCode: code(127)}, false},
+		{"exit code unknown", withExitCode(0), HistoryEvent{}, false},
+		{"over", withDurationOver(time.Minute), HistoryEvent{ExitCode: code(0), Duration: 2 * time.Minute}, true},
+		{"not over", withDurationOver(time.Minute), HistoryEvent{ExitCode: code(0), Duration: time.Minute}, false},
+		{"under", withDurationUnder(time.Second), HistoryEvent{ExitCode: code(0), Duration: time.Millisecond}, true},
+		{"under unknown", withDurationUnder(time.Second), HistoryEvent{}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.condition(tc.event))
+		})
+	}
+}
+
+func TestOutcomeAchievements(t *testing.T) {
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	build := func(minutes int, exitCode int, duration time.Duration) HistoryEvent {
+		return HistoryEvent{Cmd: "cargo", SubCommand: "build", At: start.Add(time.Duration(minutes) * time.Minute), ExitCode: &exitCode, Duration: duration}
+	}
+
+	coffee := achievement(t, "Compile time is coffee time")
+	assert.False(t, evaluate(coffee, []HistoryEvent{build(0, 0, 4*time.Minute)}).Awarded())
+	assert.True(t, evaluate(coffee, []HistoryEvent{build(0, 1, 6*time.Minute)}).Awarded())
+
+	fixed := achievement(t, "Fixed it")
+	edit := func(minutes int) HistoryEvent {
+		return HistoryEvent{Cmd: "vim", At: start.Add(time.Duration(minutes) * time.Minute)}
+	}
+	assert.False(t, evaluate(fixed, []HistoryEvent{build(0, 101, 0), build(10, 101, 0), build(20, 0, 0)}).Awarded())
+	progress := evaluate(fixed, []HistoryEvent{build(0, 101, 0), edit(5), build(10, 101, 0), build(20, 101, 0), edit(25), build(30, 0, 0)})
+	require.True(t, progress.Awarded())
+	assert.Equal(t, start.Add(30*time.Minute), *progress.AwardedAt)
+}
@@ -0,0 +1,348 @@
+package achievements
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+const (
+	// challengesPerWeek is how many challenges are picked each week
+	challengesPerWeek = 3
+
+	// trailingWeeks is how many of the weeks before a challenge its target is
+	// based on
+	trailingWeeks = 4
+
+	// challengeXP is the bonus XP for completing a weekly challenge
+	challengeXP = 100
+)
+
+// Badge is awarded for completing a weekly challenge
+type Badge struct {
+	ID        string    `json:"id"` // of the challenge, like "tests-40"
+	Name      string    `json:"name"`
+	Week      time.Time `json:"week"` // the first day of the week
+	AwardedAt time.Time `json:"awarded_at"`
+}
+
+// challengeTemplate describes a kind of weekly challenge. Its target is
+// what's usually done in a week, and then some.
+type challengeTemplate struct {
+	id          string
+	description string // formatted with the target
+	badge       string
+	condition   ConditionFunc
+	keys        func(HistoryEvent) []string // distinct keys are counted instead of events, if set
+	min         int                         // the lowest target
+}
+
+var challengeTemplates = []challengeTemplate{
+	{id: "tests", description: "Run the tests %d times", badge: "Test pilot", condition: anyTest, min: 10},
+	{id: "git-commit", description: "Make %d git commits", badge: "Committed", condition: withSubCommand("git", "commit"), min: 10},
+	{id: "git-push", description: "Push %d times", badge: "Shipper", condition: withSubCommand("git", "push"), min: 5},
+	{id: "builds", description: "Build %d times", badge: "Builder", condition: anyBuild, min: 10},
+	{id: "editor", description: "Open an editor %d times", badge: "Wordsmith", condition: anyEditor, min: 15},
+	{id: "commands", description: "Run %d commands", badge: "Busy bee", condition: anyCommand, min: 200},
+	{id: "languages", description: "Touch %d different languages", badge: "Polyglot", condition: anyCommand, keys: languages, min: 3},
+}
+
+// languageCommands and languageExts are the languages that commands and
+// the files they are run on are written in
+var (
+	languageCommands = map[string]string{
+		"go": "go", "gofmt": "go",
+		"python": "python", "python2": "python", "python3": "python", "pip": "python", "pip3": "python", "pytest": "python", "poetry": "python",
+		"node": "javascript", "npm": "javascript", "npx": "javascript", "yarn": "javascript", "pnpm": "javascript", "deno": "javascript", "bun": "javascript",
+		"tsc":   "typescript",
+		"cargo": "rust", "rustc": "rust",
+		"java": "java", "javac": "java", "mvn": "java", "gradle": "java", "gradlew": "java",
+		"kotlin": "kotlin", "kotlinc": "kotlin",
+		"ruby": "ruby", "gem": "ruby", "bundle": "ruby", "rake": "ruby", "rails": "ruby",
+		"swift": "swift", "swiftc": "swift",
+		"dotnet": "csharp",
+		"php":    "php", "composer": "php",
+		"gcc": "c", "clang": "c",
+		"g++": "c++", "clang++": "c++",
+		"ghc": "haskell", "cabal": "haskell", "stack": "haskell",
+		"elixir": "elixir", "mix": "elixir", "iex": "elixir",
+	}
+	languageExts = map[string]string{
+		"go": "go", "py": "python", "js": "javascript", "jsx": "javascript", "mjs": "javascript",
+		"ts": "typescript", "tsx": "typescript", "rs": "rust", "java": "java", "kt": "kotlin",
+		"rb": "ruby", "swift": "swift", "cs": "csharp", "php": "php", "c": "c", "h": "c",
+		"cpp": "c++", "cc": "c++", "hpp": "c++", "hs": "haskell", "ex": "elixir", "exs": "elixir",
+		"sh": "shell", "bash": "shell", "zsh": "shell", "lua": "lua", "sql": "sql",
+	}
+)
+
+// languages returns the languages that the event touched
+func languages(event HistoryEvent) []string {
+	var res []string
+	if lang, ok := languageCommands[event.Cmd]; ok {
+		res = append(res, lang)
+	}
+	for _, ext := range event.FileExtensions {
+		if lang, ok := languageExts[strings.ToLower(ext)]; ok {
+			res = append(res, lang)
+		}
+	}
+	return res
+}
+
+// measure is how much of the template was done with the events
+func (t challengeTemplate) measure(events []HistoryEvent) int {
+	count := 0
+	seen := map[string]bool{}
+	for _, e := range events {
+		if !t.condition(e) {
+			continue
+		}
+		if t.keys == nil {
+			count++
+			continue
+		}
+		for _, key := range t.keys(e) {
+			if !seen[key] {
+				seen[key] = true
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// target is the target of a challenge when the template is usually done
+// average times a week. It goes up with the difficulty, in percent of the
+// average, but never below the minimum.
+func (t challengeTemplate) target(average float64, difficulty int) int {
+	target := int(math.Ceil(average * float64(difficulty) / 100))
+	if target < t.min {
+		return t.min
+	}
+	return target
+}
+
+func (t challengeTemplate) quest(start time.Time, calendar Calendar, target int) Quest {
+	during := and(inPeriod(calendar, start, Weekly), t.condition)
+	fn := nth(during, target)
+	if t.keys != nil {
+		fn = distinct(during, t.keys, target)
+	}
+	return Quest{
+		ID:          fmt.Sprintf("%s-%d", t.id, target),
+		Description: fmt.Sprintf(t.description, target),
+		Period:      Weekly,
+		Start:       start,
+		Badge:       t.badge,
+		Func:        fn,
+	}
+}
+
+// difficulty is how much harder than usual challenges are in percent, after
+// completing challenges the given number of weeks in a row
+func difficulty(weeks int) int {
+	if weeks >= 9 {
+		return 200
+	}
+	return 110 + 10*weeks
+}
+
+// WeeklyChallenges picks the challenges for the week that t is in. As with
+// daily quests the same challenges are picked all week for the pet, but the
+// targets are personal: they are based on the average of the weeks before in
+// the wal, and go up for every week in a row that challenges were
+// completed. Once the targets have been picked they are kept for the week.
+func (e *Engine) WeeklyChallenges(calendar Calendar, t time.Time, petName string) ([]Quest, error) {
+	start := Weekly.start(calendar.Day(t))
+	r := questRand(start, Weekly, petName)
+
+	// targets that were picked already, by template
+	picked := map[string]int{}
+	prefix := Quest{Start: start, Period: Weekly}.key()
+	for key := range e.snapshot.Quests {
+		id := strings.TrimPrefix(key, prefix)
+		if id == key {
+			continue
+		}
+		if i := strings.LastIndexByte(id, '-'); i > 0 {
+			if target, err := strconv.Atoi(id[i+1:]); err == nil {
+				picked[id[:i]] = target
+			}
+		}
+	}
+
+	var averages map[string]float64
+	var quests []Quest
+	for _, i := range r.Perm(len(challengeTemplates))[:challengesPerWeek] {
+		tmpl := challengeTemplates[i]
+		target, ok := picked[tmpl.id]
+		if !ok {
+			if averages == nil {
+				var err error
+				if averages, err = e.weeklyAverages(calendar, start); err != nil {
+					return nil, err
+				}
+			}
+			target = tmpl.target(averages[tmpl.id], difficulty(e.completedWeeks(start)))
+		}
+		quests = append(quests, tmpl.quest(start, calendar, target))
+	}
+	return quests, nil
+}
+
+// weeklyAverages returns how much of each template was done on average in
+// the weeks before the one starting on start. Only weeks that are entirely
+// in the wal are counted.
+func (e *Engine) weeklyAverages(calendar Calendar, start time.Time) (map[string]float64, error) {
+	averages := map[string]float64{}
+
+	file, err := state.OpenLocked(walPath(e.storagePath), os.O_RDONLY, 0, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return averages, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read wal: %w", err)
+	}
+	defer file.Close()
+
+	events, _, err := readEvents(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return averages, nil
+	}
+
+	// the first week of history counts even if it's only partly used, but a
+	// week that was partly compacted doesn't
+	from := Weekly.start(calendar.Day(events[0].At))
+	if !e.snapshot.Until.IsZero() {
+		from = Weekly.next(Weekly.start(calendar.Day(e.snapshot.Until)))
+	}
+	if earliest := start.AddDate(0, 0, -7*trailingWeeks); from.Before(earliest) {
+		from = earliest
+	}
+	if !from.Before(start) {
+		return averages, nil
+	}
+
+	weeks := map[time.Time][]HistoryEvent{}
+	for _, event := range events {
+		week := Weekly.start(calendar.Day(event.At))
+		if !week.Before(from) && week.Before(start) {
+			weeks[week] = append(weeks[week], event)
+		}
+	}
+
+	n := 0
+	for week := from; week.Before(start); week = Weekly.next(week) {
+		n++
+		for _, tmpl := range challengeTemplates {
+			averages[tmpl.id] += float64(tmpl.measure(weeks[week]))
+		}
+	}
+	for id := range averages {
+		averages[id] /= float64(n)
+	}
+	return averages, nil
+}
+
+// completedWeeks is how many weeks in a row before the one starting on start
+// a challenge was completed
+func (e *Engine) completedWeeks(start time.Time) int {
+	completed := map[time.Time]bool{}
+	for _, b := range e.snapshot.Badges {
+		completed[b.Week.UTC()] = true
+	}
+	n := 0
+	for week := start.AddDate(0, 0, -7); completed[week]; week = week.AddDate(0, 0, -7) {
+		n++
+	}
+	return n
+}
+
+// awardBadges records a badge for each tracked challenge that was completed
+func (e *Engine) awardBadges() {
+	awarded := map[string]bool{}
+	for _, b := range e.snapshot.Badges {
+		awarded[Quest{ID: b.ID, Period: Weekly, Start: b.Week}.key()] = true
+	}
+	for _, q := range e.tracked {
+		if q.Badge == "" || awarded[q.key()] {
+			continue
+		}
+		if p := e.QuestProgress(q); p.Awarded() {
+			e.snapshot.Badges = append(e.snapshot.Badges, Badge{ID: q.ID, Name: q.Badge, Week: q.Start, AwardedAt: *p.AwardedAt})
+		}
+	}
+	sort.SliceStable(e.snapshot.Badges, func(a, b int) bool {
+		return e.snapshot.Badges[a].AwardedAt.Before(e.snapshot.Badges[b].AwardedAt)
+	})
+}
+
+// Badges returns the badges for completed challenges, the latest last
+func (e *Engine) Badges() []Badge {
+	return e.snapshot.Badges
+}
+
+// distinctCounter counts the distinct keys of events matching a condition,
+// and is awarded once there are n of them
+type distinctCounter struct {
+	condition ConditionFunc
+	keys      func(HistoryEvent) []string
+	n         int
+	state     distinctState
+}
+
+type distinctState struct {
+	Progress
+	Seen []string `json:"seen,omitempty"`
+}
+
+func (d *distinctCounter) Add(event HistoryEvent) {
+	if !d.condition(event) {
+		return
+	}
+	for _, key := range d.keys(event) {
+		if d.seen(key) {
+			continue
+		}
+		d.state.Seen = append(d.state.Seen, key)
+		d.state.Count++
+		if d.state.Count == d.n && !d.state.Awarded() {
+			at := event.At
+			d.state.AwardedAt = &at
+		}
+	}
+}
+
+func (d *distinctCounter) seen(key string) bool {
+	for _, s := range d.state.Seen {
+		if s == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *distinctCounter) Progress() Progress {
+	p := d.state.Progress
+	p.Target = d.n
+	return p
+}
+
+func (d *distinctCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.state)
+}
+
+func (d *distinctCounter) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &d.state)
+}
@@ -0,0 +1,183 @@
+package achievements
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+// appendEvents appends the events to the wal
+func appendEvents(t testing.TB, storagePath state.StoragePath, events ...HistoryEvent) {
+	fp, err := os.OpenFile(walPath(storagePath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for _, e := range events {
+		raw, err := json.Marshal(e)
+		require.NoError(t, err)
+		w.Write(raw)
+		w.WriteByte('\n')
+	}
+	require.NoError(t, w.Flush())
+}
+
+// repeat returns n events a minute apart, starting at the time
+func repeat(e HistoryEvent, start time.Time, n int) []HistoryEvent {
+	var res []HistoryEvent
+	for i := 0; i < n; i++ {
+		e.At = start.Add(time.Duration(i) * time.Minute)
+		res = append(res, e)
+	}
+	return res
+}
+
+func challengeTemplateByID(t testing.TB, id string) challengeTemplate {
+	for _, tmpl := range challengeTemplates {
+		if strings.HasPrefix(id, tmpl.id+"-") {
+			return tmpl
+		}
+	}
+	t.Fatalf("no template for %q", id)
+	return challengeTemplate{}
+}
+
+func TestLanguages(t *testing.T) {
+	fn := distinct(anyCommand, languages, 3)
+	ev := fn()
+	for _, e := range []HistoryEvent{
+		{Cmd: "go", SubCommand: "test"},
+		{Cmd: "vim", FileExtensions: []string{"go", "PY"}},
+		{Cmd: "ls"},
+		{Cmd: "python3"},
+		{Cmd: "cargo", At: time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)},
+		{Cmd: "npm"},
+	} {
+		ev.Add(e)
+	}
+	assert.Equal(t, 4, ev.Progress().Count)
+	require.True(t, ev.Progress().Awarded())
+	assert.Equal(t, time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC), *ev.Progress().AwardedAt)
+
+	raw, err := json.Marshal(ev)
+	require.NoError(t, err)
+	restored := fn()
+	require.NoError(t, json.Unmarshal(raw, restored))
+	restored.Add(HistoryEvent{Cmd: "go"})
+	assert.Equal(t, 4, restored.Progress().Count)
+}
+
+func TestChallengeTargets(t *testing.T) {
+	cal := Calendar{Location: time.UTC}
+	week := time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC) // a Monday
+	tests := HistoryEvent{Cmd: "go", SubCommand: "test"}
+
+	storagePath := state.StoragePath(t.TempDir())
+	// 20 test runs in each of the two weeks before, and some this week
+	appendEvents(t, storagePath, repeat(tests, week.AddDate(0, 0, -14), 20)...)
+	appendEvents(t, storagePath, repeat(tests, week.AddDate(0, 0, -7), 20)...)
+	appendEvents(t, storagePath, repeat(tests, week.Add(time.Hour), 50)...)
+
+	engine, err := NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	averages, err := engine.weeklyAverages(cal, week)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, averages["tests"])
+	assert.Equal(t, 1.0, averages["languages"])
+	assert.Equal(t, 0.0, averages["git-push"])
+
+	// weeks from before the history started don't count, but the weeks
+	// without any use since do
+	averages, err = engine.weeklyAverages(cal, week.AddDate(0, 0, 14))
+	require.NoError(t, err)
+	assert.Equal(t, 90.0/4, averages["tests"])
+
+	tmpl := challengeTemplateByID(t, "tests-1")
+	assert.Equal(t, 22, tmpl.target(20, difficulty(0)))
+	assert.Equal(t, tmpl.min, tmpl.target(2, difficulty(0)))
+	assert.Equal(t, 24, tmpl.target(20, difficulty(1)))
+	assert.Equal(t, 40, tmpl.target(20, difficulty(100)))
+}
+
+func TestWeeklyChallenges(t *testing.T) {
+	cal := Calendar{Location: time.UTC}
+	week := time.Date(2022, 10, 31, 0, 0, 0, 0, time.UTC)
+	storagePath := state.StoragePath(t.TempDir())
+
+	engine, err := NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	challenges, err := engine.WeeklyChallenges(cal, week.AddDate(0, 0, 3), "Marble")
+	require.NoError(t, err)
+	require.Len(t, challenges, challengesPerWeek)
+
+	// with no history, the targets are the lowest ones
+	for _, c := range challenges {
+		tmpl := challengeTemplateByID(t, c.ID)
+		assert.Equal(t, week, c.Start)
+		assert.Equal(t, Weekly, c.Period)
+		assert.Equal(t, tmpl.badge, c.Badge)
+		assert.Equal(t, tmpl.quest(week, cal, tmpl.min).ID, c.ID)
+	}
+	require.NoError(t, engine.TrackQuests(challenges))
+
+	// complete the first challenge
+	first := challengeTemplateByID(t, challenges[0].ID)
+	var e HistoryEvent
+	switch first.id {
+	case "tests":
+		e = HistoryEvent{Cmd: "go", SubCommand: "test"}
+	case "git-commit":
+		e = HistoryEvent{Cmd: "git", SubCommand: "commit"}
+	case "git-push":
+		e = HistoryEvent{Cmd: "git", SubCommand: "push"}
+	case "builds":
+		e = HistoryEvent{Cmd: "make"}
+	case "editor":
+		e = HistoryEvent{Cmd: "vim"}
+	case "commands":
+		e = HistoryEvent{Cmd: "ls"}
+	}
+	var events []HistoryEvent
+	if first.id == "languages" {
+		for i, cmd := range []string{"go", "python", "cargo"} {
+			events = append(events, HistoryEvent{Cmd: cmd, At: week.Add(time.Duration(i) * time.Minute)})
+		}
+	} else {
+		events = repeat(e, week.Add(time.Hour), first.min)
+	}
+	appendEvents(t, storagePath, events...)
+	xp := engine.XP()
+	require.NoError(t, engine.Update())
+
+	require.True(t, engine.QuestProgress(challenges[0]).Awarded())
+	require.Len(t, engine.Badges(), 1)
+	assert.Equal(t, Badge{ID: challenges[0].ID, Name: first.badge, Week: week, AwardedAt: events[len(events)-1].At}, engine.Badges()[0])
+	assert.GreaterOrEqual(t, engine.XP()-xp, challengeXP)
+
+	// the same challenges and targets are kept all week, even as more
+	// history comes in
+	engine, err = NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	again, err := engine.WeeklyChallenges(cal, week.AddDate(0, 0, 6), "Marble")
+	require.NoError(t, err)
+	for i := range challenges {
+		assert.Equal(t, challenges[i].key(), again[i].key())
+	}
+	require.NoError(t, engine.TrackQuests(again))
+	require.NoError(t, engine.Update())
+	assert.Len(t, engine.Badges(), 1, "badges are only awarded once")
+
+	// completing challenges makes the next ones harder
+	assert.Equal(t, 1, engine.completedWeeks(week.AddDate(0, 0, 7)))
+	assert.Equal(t, 0, engine.completedWeeks(week.AddDate(0, 0, 14)))
+	next, err := engine.WeeklyChallenges(cal, week.AddDate(0, 0, 7), "Marble")
+	require.NoError(t, err)
+	assert.Equal(t, week.AddDate(0, 0, 7), next[0].Start)
+}
@@ -0,0 +1,709 @@
+package achievements
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+// definitionsDir is where achievements can be defined in the storage path,
+// see ParseDefinitions
+const definitionsDir = "achievements"
+
+// DefinitionError points at what's wrong in a file with achievement definitions
+type DefinitionError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// DefinitionErrors are all errors found when loading definitions
+type DefinitionErrors []*DefinitionError
+
+func (e DefinitionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Load returns the built-in achievements together with the ones defined in the
+// storage path, and the ones from installed packs. Invalid definitions are
+// skipped and returned as DefinitionErrors, together with all valid
+// achievements.
+func Load(storagePath state.StoragePath) ([]Achievement, error) {
+	all := append([]Achievement{}, Achievements...)
+
+	files, err := filepath.Glob(path.Join(string(storagePath), definitionsDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list achievements: %w", err)
+	}
+	sort.Strings(files)
+
+	var errs DefinitionErrors
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read achievements: %w", err)
+		}
+
+		defined, err := ParseDefinitions(file, data)
+		var defErrs DefinitionErrors
+		if errors.As(err, &defErrs) {
+			errs = append(errs, defErrs...)
+		} else if err != nil {
+			return nil, err
+		}
+		all = append(all, defined...)
+	}
+
+	packs, err := InstalledPacks(storagePath)
+	var defErrs DefinitionErrors
+	if errors.As(err, &defErrs) {
+		errs = append(errs, defErrs...)
+	} else if err != nil {
+		return nil, err
+	}
+	for _, pack := range packs {
+		all = append(all, pack.Achievements...)
+	}
+
+	all, dupErrs := dedupeAchievements(all)
+	errs = append(errs, dupErrs...)
+
+	if len(errs) > 0 {
+		return all, errs
+	}
+	return all, nil
+}
+
+// dedupeAchievements drops achievements with the same key as an earlier one
+func dedupeAchievements(all []Achievement) ([]Achievement, DefinitionErrors) {
+	var errs DefinitionErrors
+	seen := make(map[string]struct{}, len(all))
+	res := all[:0]
+	for _, a := range all {
+		if _, ok := seen[a.Key()]; ok {
+			errs = append(errs, &DefinitionError{File: a.file, Line: a.line, Msg: fmt.Sprintf("duplicate achievement %q", a.Key())})
+			continue
+		}
+		seen[a.Key()] = struct{}{}
+		res = append(res, a)
+	}
+	return res, errs
+}
+
+// ParseDefinitions parses the achievements defined in a file, the format is
+// described under "Custom achievements" in the README. Invalid achievements
+// are skipped, and returned as DefinitionErrors together with the valid ones.
+func ParseDefinitions(file string, data []byte) ([]Achievement, error) {
+	l := &loader{file: file, data: data}
+
+	var defined []Achievement
+	if root, ok := l.root(); ok {
+		if fields, ok := l.object(root, "achievements"); ok {
+			defined = l.achievements(fields["achievements"], root)
+		}
+	}
+
+	if len(l.errs) > 0 {
+		return defined, l.errs
+	}
+	return defined, nil
+}
+
+type loader struct {
+	file string
+	data []byte
+	errs DefinitionErrors
+}
+
+func (l *loader) root() (*node, bool) {
+	root, err := parseNode(l.data)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		offset := int64(len(l.data))
+		if errors.As(err, &syntaxErr) {
+			offset = syntaxErr.Offset
+		}
+		l.errorf(offset, "invalid json: %v", err)
+		return nil, false
+	}
+	return root, true
+}
+
+func (l *loader) achievements(n, parent *node) []Achievement {
+	if n == nil {
+		l.errorf(parent.offset, "missing achievements")
+		return nil
+	}
+	list, ok := l.array(n)
+	if !ok {
+		return nil
+	}
+
+	var defined []Achievement
+	for _, item := range list {
+		if a, ok := l.achievement(item); ok {
+			defined = append(defined, a)
+		}
+	}
+	return defined
+}
+
+func (l *loader) errorf(offset int64, format string, args ...interface{}) {
+	l.errs = append(l.errs, &DefinitionError{
+		File: l.file,
+		Line: 1 + bytes.Count(l.data[:offset], []byte("\n")),
+		Msg:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *loader) achievement(n *node) (Achievement, bool) {
+	fields, ok := l.object(n, "id", "name", "description", "category", "tags", "rarity", "first", "nth", "streak", "sequence")
+	if !ok {
+		return Achievement{}, false
+	}
+
+	a := Achievement{file: l.file, line: l.line(n)}
+
+	name, ok := l.str(fields["name"], n, "name")
+	if !ok {
+		return Achievement{}, false
+	}
+	if len(name) > achievementNameMaxLength {
+		l.errorf(fields["name"].offset, "name is too long, %d characters when at most %d are allowed", len(name), achievementNameMaxLength)
+		return Achievement{}, false
+	}
+	a.Name = name
+
+	if id := fields["id"]; id != nil {
+		if a.ID, ok = l.str(id, n, "id"); !ok {
+			return Achievement{}, false
+		}
+		if !idPattern.MatchString(a.ID) {
+			l.errorf(id.offset, "invalid id %q, only lowercase letters, digits, '.', '_' and '-' are allowed", a.ID)
+			return Achievement{}, false
+		}
+	} else if a.ID = slug(name); a.ID == "" {
+		l.errorf(n.offset, "missing id, and it can't be derived from the name")
+		return Achievement{}, false
+	}
+
+	if desc := fields["description"]; desc != nil {
+		if a.Description, ok = l.str(desc, n, "description"); !ok {
+			return Achievement{}, false
+		}
+	}
+	if category := fields["category"]; category != nil {
+		if a.Category, ok = l.str(category, n, "category"); !ok {
+			return Achievement{}, false
+		}
+	}
+	if tags := fields["tags"]; tags != nil {
+		if a.Tags, ok = l.strings(tags); !ok {
+			return Achievement{}, false
+		}
+	}
+	if rarity := fields["rarity"]; rarity != nil {
+		name, ok := l.str(rarity, n, "rarity")
+		if !ok {
+			return Achievement{}, false
+		}
+		if a.Rarity = Rarity(name); !a.Rarity.valid() {
+			l.errorf(rarity.offset, "unknown rarity %q, must be common, rare, epic or legendary", name)
+			return Achievement{}, false
+		}
+	}
+
+	var kinds []string
+	for _, kind := range []string{"first", "nth", "streak", "sequence"} {
+		if fields[kind] != nil {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) == 0 {
+		l.errorf(n.offset, "missing first, nth, streak or sequence")
+		return Achievement{}, false
+	}
+	if len(kinds) > 1 {
+		l.errorf(fields[kinds[1]].offset, "only one of first, nth, streak and sequence can be used")
+		return Achievement{}, false
+	}
+
+	switch kinds[0] {
+	case "first":
+		condition, ok := l.condition(fields["first"])
+		if !ok {
+			return Achievement{}, false
+		}
+		a.Func = nth(condition, 1)
+	case "nth":
+		_, count, condition, ok := l.counted(fields["nth"])
+		if !ok {
+			return Achievement{}, false
+		}
+		a.Func = nth(condition, count)
+	case "streak":
+		streakFields, count, condition, ok := l.counted(fields["streak"], "period")
+		if !ok {
+			return Achievement{}, false
+		}
+		period := Daily
+		if p := streakFields["period"]; p != nil {
+			name, ok := l.str(p, fields["streak"], "period")
+			if !ok {
+				return Achievement{}, false
+			}
+			if period = Period(name); !period.valid() {
+				l.errorf(p.offset, "unknown period %q, must be daily, weekdays or weekly", name)
+				return Achievement{}, false
+			}
+		}
+		a.Func = streak(condition, period, count)
+	case "sequence":
+		count, steps, ok := l.sequence(fields["sequence"])
+		if !ok {
+			return Achievement{}, false
+		}
+		a.Func = sequence(count, steps...)
+	}
+
+	return a, true
+}
+
+// idPattern is what IDs look like, they are used as keys in the snapshot and
+// should be easy to type
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// slug derives an ID from a name, for achievements that don't have one
+func slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// counted parses an object with a count "n" and the condition it counts "of",
+// like the one for nth, and returns its fields for the extra ones
+func (l *loader) counted(n *node, extra ...string) (map[string]*node, int, ConditionFunc, bool) {
+	fields, ok := l.object(n, append([]string{"n", "of"}, extra...)...)
+	if !ok {
+		return nil, 0, nil, false
+	}
+	count, ok := l.int(fields["n"], n, "n")
+	if !ok {
+		return nil, 0, nil, false
+	}
+	if count < 1 {
+		l.errorf(fields["n"].offset, "n must be at least 1")
+		return nil, 0, nil, false
+	}
+	if fields["of"] == nil {
+		l.errorf(n.offset, "missing of")
+		return nil, 0, nil, false
+	}
+	condition, ok := l.condition(fields["of"])
+	return fields, count, condition, ok
+}
+
+// sequence parses the steps of a sequence, and how many times it has to be
+// matched
+func (l *loader) sequence(n *node) (int, []Step, bool) {
+	fields, ok := l.object(n, "n", "steps")
+	if !ok {
+		return 0, nil, false
+	}
+	count := 1
+	if fields["n"] != nil {
+		if count, ok = l.int(fields["n"], n, "n"); !ok {
+			return 0, nil, false
+		}
+		if count < 1 {
+			l.errorf(fields["n"].offset, "n must be at least 1")
+			return 0, nil, false
+		}
+	}
+	list, ok := l.array(fields["steps"])
+	if !ok {
+		return 0, nil, false
+	}
+	if len(list) == 0 {
+		l.errorf(fields["steps"].offset, "steps can't be empty")
+		return 0, nil, false
+	}
+
+	steps := make([]Step, 0, len(list))
+	for _, item := range list {
+		stepFields, ok := l.object(item, "of", "within", "gap")
+		if !ok {
+			return 0, nil, false
+		}
+		if stepFields["of"] == nil {
+			l.errorf(item.offset, "missing of")
+			return 0, nil, false
+		}
+		var step Step
+		if step.Condition, ok = l.condition(stepFields["of"]); !ok {
+			return 0, nil, false
+		}
+		if within := stepFields["within"]; within != nil {
+			if step.Within, ok = l.duration(within, item, "within"); !ok {
+				return 0, nil, false
+			}
+			// unless a gap is given, any number of events can come in the time
+			step.Gap = AnyGap
+		}
+		if gap := stepFields["gap"]; gap != nil {
+			if gap.value == "any" {
+				step.Gap = AnyGap
+			} else if step.Gap, ok = l.int(gap, item, "gap"); !ok {
+				return 0, nil, false
+			} else if step.Gap < 0 {
+				l.errorf(gap.offset, "gap must be a number of events, or \"any\"")
+				return 0, nil, false
+			}
+		}
+		steps = append(steps, step)
+	}
+	return count, steps, true
+}
+
+func (l *loader) condition(n *node) (ConditionFunc, bool) {
+	if n.kind != objectNode || len(n.object) != 1 {
+		l.errorf(n.offset, "a condition must be an object with exactly one key")
+		return nil, false
+	}
+	key, value := n.object[0].key, n.object[0].value
+
+	switch key {
+	case "command":
+		cmd, ok := l.str(value, n, key)
+		return withCommand(cmd), ok
+	case "subcommand":
+		parts, ok := l.strings(value)
+		if ok && len(parts) != 2 {
+			l.errorf(value.offset, "subcommand must be a command and a subcommand, like [\"git\", \"commit\"]")
+			return nil, false
+		}
+		if !ok {
+			return nil, false
+		}
+		return withSubCommand(parts[0], parts[1]), true
+	case "flag":
+		flag, ok := l.str(value, n, key)
+		return withFlag(flag), ok
+	case "wrapper":
+		wrapper, ok := l.str(value, n, key)
+		return withWrapper(wrapper), ok
+	case "hour_range":
+		list, ok := l.array(value)
+		if !ok {
+			return nil, false
+		}
+		if len(list) != 2 {
+			l.errorf(value.offset, "hour_range must be two hours, like [2, 5]")
+			return nil, false
+		}
+		min, ok := l.int(list[0], value, key)
+		if !ok {
+			return nil, false
+		}
+		max, ok := l.int(list[1], value, key)
+		if !ok {
+			return nil, false
+		}
+		if min < 0 || max > 23 || min > max {
+			l.errorf(value.offset, "invalid hour_range [%d, %d], hours must be between 0 and 23", min, max)
+			return nil, false
+		}
+		return withHourRange(min, max), true
+	case "exts":
+		exts, ok := l.strings(value)
+		if ok && len(exts) == 0 {
+			l.errorf(value.offset, "exts can't be empty")
+			return nil, false
+		}
+		return withExts(exts...), ok
+	case "exit_code":
+		code, ok := l.int(value, n, key)
+		return withExitCode(code), ok
+	case "outcome":
+		outcome, ok := l.str(value, n, key)
+		if !ok {
+			return nil, false
+		}
+		switch outcome {
+		case "success":
+			return succeeded, true
+		case "failure":
+			return failed, true
+		default:
+			l.errorf(value.offset, "unknown outcome %q, must be success or failure", outcome)
+			return nil, false
+		}
+	case "longer_than", "shorter_than":
+		d, ok := l.duration(value, n, key)
+		if key == "longer_than" {
+			return withDurationOver(d), ok
+		}
+		return withDurationUnder(d), ok
+	case "and", "or":
+		list, ok := l.array(value)
+		if !ok {
+			return nil, false
+		}
+		if len(list) == 0 {
+			l.errorf(value.offset, "%s can't be empty", key)
+			return nil, false
+		}
+		var conditions []ConditionFunc
+		for _, item := range list {
+			c, ok := l.condition(item)
+			if !ok {
+				return nil, false
+			}
+			conditions = append(conditions, c)
+		}
+		if key == "and" {
+			return and(conditions...), true
+		}
+		return or(conditions...), true
+	default:
+		l.errorf(n.object[0].offset, "unknown condition %q", key)
+		return nil, false
+	}
+}
+
+// object checks that the node is an object with only the allowed keys
+func (l *loader) object(n *node, allowed ...string) (map[string]*node, bool) {
+	if n.kind != objectNode {
+		l.errorf(n.offset, "expected an object")
+		return nil, false
+	}
+	fields := make(map[string]*node, len(n.object))
+	for _, m := range n.object {
+		if !contains(allowed, m.key) {
+			l.errorf(m.offset, "unknown field %q", m.key)
+			return nil, false
+		}
+		fields[m.key] = m.value
+	}
+	return fields, true
+}
+
+func (l *loader) array(n *node) ([]*node, bool) {
+	if n == nil || n.kind != arrayNode {
+		l.errorf(l.offset(n), "expected a list")
+		return nil, false
+	}
+	return n.array, true
+}
+
+// str returns the string value of a field of the parent
+func (l *loader) str(n, parent *node, field string) (string, bool) {
+	if n == nil {
+		l.errorf(parent.offset, "missing %s", field)
+		return "", false
+	}
+	s, ok := n.value.(string)
+	if !ok || n.kind != valueNode {
+		l.errorf(n.offset, "%s must be a string", field)
+		return "", false
+	}
+	if s == "" {
+		l.errorf(n.offset, "%s can't be empty", field)
+		return "", false
+	}
+	return s, true
+}
+
+func (l *loader) int(n, parent *node, field string) (int, bool) {
+	if n == nil {
+		l.errorf(parent.offset, "missing %s", field)
+		return 0, false
+	}
+	num, ok := n.value.(json.Number)
+	if !ok {
+		l.errorf(n.offset, "%s must be a number", field)
+		return 0, false
+	}
+	i, err := num.Int64()
+	if err != nil {
+		l.errorf(n.offset, "%s must be a whole number", field)
+		return 0, false
+	}
+	return int(i), true
+}
+
+// duration parses a positive duration like "5s" or "1h"
+func (l *loader) duration(n, parent *node, field string) (time.Duration, bool) {
+	s, ok := l.str(n, parent, field)
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		l.errorf(n.offset, "invalid %s %q, must be a duration like \"5s\" or \"1h\"", field, s)
+		return 0, false
+	}
+	return d, true
+}
+
+func (l *loader) strings(n *node) ([]string, bool) {
+	list, ok := l.array(n)
+	if !ok {
+		return nil, false
+	}
+	res := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.value.(string)
+		if !ok || item.kind != valueNode || s == "" {
+			l.errorf(item.offset, "expected a list of strings")
+			return nil, false
+		}
+		res = append(res, s)
+	}
+	return res, true
+}
+
+func (l *loader) offset(n *node) int64 {
+	if n == nil {
+		return 0
+	}
+	return n.offset
+}
+
+func (l *loader) line(n *node) int {
+	return 1 + bytes.Count(l.data[:n.offset], []byte("\n"))
+}
+
+type nodeKind int
+
+const (
+	valueNode nodeKind = iota
+	objectNode
+	arrayNode
+)
+
+// node is a parsed JSON value, that remembers where in the file it was
+type node struct {
+	kind   nodeKind
+	offset int64
+	value  interface{} // string, json.Number, bool or nil
+	object []member
+	array  []*node
+}
+
+type member struct {
+	key    string
+	offset int64
+	value  *node
+}
+
+func parseNode(data []byte) (*node, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	n, err := parseValue(dec, data)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, &json.SyntaxError{Offset: dec.InputOffset()}
+	}
+	return n, nil
+}
+
+func parseValue(dec *json.Decoder, data []byte) (*node, error) {
+	offset := tokenStart(data, dec.InputOffset())
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{offset: offset}
+	switch tok {
+	case json.Delim('{'):
+		n.kind = objectNode
+		for dec.More() {
+			keyOffset := tokenStart(data, dec.InputOffset())
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseValue(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			n.object = append(n.object, member{key: key.(string), offset: keyOffset, value: value})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	case json.Delim('['):
+		n.kind = arrayNode
+		for dec.More() {
+			value, err := parseValue(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			n.array = append(n.array, value)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+	default:
+		n.value = tok
+	}
+	return n, nil
+}
+
+// tokenStart skips the separators between the end of the last token and the
+// start of the next one
+func tokenStart(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) {
+		switch data[offset] {
+		case ' ', '\t', '\r', '\n', ',', ':':
+			offset++
+		default:
+			return offset
+		}
+	}
+	return offset
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package achievements
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+const ourctl = `{
+  "achievements": [
+    {
+      "id": "ship-it",
+      "name": "Ship it",
+      "description": "Deploy with ourctl",
+      "category": "deploys",
+      "tags": ["ourctl", "cd"],
+      "rarity": "epic",
+      "first": {"subcommand": ["ourctl", "deploy"]}
+    },
+    {
+      "name": "Serial shipper",
+      "description": "Deploy with ourctl 3 times, outside office hours",
+      "nth": {
+        "n": 3,
+        "of": {"and": [
+          {"subcommand": ["ourctl", "deploy"]},
+          {"or": [{"hour_range": [0, 8]}, {"hour_range": [18, 23]}]}
+        ]}
+      }
+    },
+    {
+      "name": "Daily deployer",
+      "streak": {"n": 3, "period": "weekdays", "of": {"subcommand": ["ourctl", "deploy"]}}
+    },
+    {
+      "name": "Regret",
+      "sequence": {"steps": [
+        {"of": {"subcommand": ["ourctl", "deploy"]}},
+        {"of": {"subcommand": ["ourctl", "rollback"]}, "within": "10m", "gap": 1}
+      ]}
+    },
+    {
+      "name": "Forceful",
+      "first": {"and": [{"command": "ourctl"}, {"flag": "--force"}, {"wrapper": "sudo"}, {"exts": ["yaml"]},
+        {"outcome": "failure"}, {"exit_code": 130}, {"longer_than": "1m"}, {"shorter_than": "1h"}]}
+    }
+  ]
+}`
+
+func evaluate(a Achievement, events []HistoryEvent) Progress {
+	ev := a.Func()
+	for _, e := range events {
+		ev.Add(e)
+	}
+	return ev.Progress()
+}
+
+func TestParseDefinitions(t *testing.T) {
+	defined, err := ParseDefinitions("ourctl.json", []byte(ourctl))
+	require.NoError(t, err)
+	require.Len(t, defined, 5)
+
+	assert.Equal(t, "ship-it", defined[0].ID)
+	assert.Equal(t, "Ship it", defined[0].Name)
+	assert.Equal(t, "Deploy with ourctl", defined[0].Description)
+	assert.Equal(t, "deploys", defined[0].Category)
+	assert.Equal(t, []string{"ourctl", "cd"}, defined[0].Tags)
+	assert.Equal(t, Epic, defined[0].Tier())
+	assert.Equal(t, Common, defined[1].Tier())
+
+	// the id is derived from the name when it's left out
+	assert.Equal(t, "serial-shipper", defined[1].ID)
+
+	day := time.Date(2022, 11, 1, 0, 0, 0, 0, time.Local)
+	deploy := func(hour int) HistoryEvent {
+		return HistoryEvent{Cmd: "ourctl", SubCommand: "deploy", At: day.Add(time.Duration(hour) * time.Hour)}
+	}
+
+	events := []HistoryEvent{deploy(7), deploy(12), deploy(19), deploy(23)}
+	assert.Equal(t, day.Add(7*time.Hour), *evaluate(defined[0], events).AwardedAt)
+	assert.Equal(t, day.Add(23*time.Hour), *evaluate(defined[1], events).AwardedAt)
+	assert.False(t, evaluate(defined[2], events).Awarded())
+	assert.False(t, evaluate(defined[4], events).Awarded())
+
+	// Tuesday to Thursday
+	assert.Equal(t, day.Add(48*time.Hour), *evaluate(defined[2], []HistoryEvent{deploy(12), deploy(36), deploy(48)}).AwardedAt)
+
+	rollback := func(minutes int) HistoryEvent {
+		return HistoryEvent{Cmd: "ourctl", SubCommand: "rollback", At: day.Add(12*time.Hour + time.Duration(minutes)*time.Minute)}
+	}
+	ls := HistoryEvent{Cmd: "ls", At: day.Add(12 * time.Hour)}
+	assert.False(t, evaluate(defined[3], []HistoryEvent{deploy(12), ls, ls, rollback(1)}).Awarded())
+	assert.False(t, evaluate(defined[3], []HistoryEvent{deploy(12), rollback(11)}).Awarded())
+	assert.Equal(t, day.Add(12*time.Hour+time.Minute), *evaluate(defined[3], []HistoryEvent{deploy(12), ls, rollback(1)}).AwardedAt)
+
+	interrupted := 130
+	forced := HistoryEvent{Cmd: "ourctl", Flags: []string{"--force"}, Wrappers: []string{"sudo"}, FileExtensions: []string{"yaml"}, ExitCode: &interrupted, Duration: 10 * time.Minute}
+	assert.True(t, evaluate(defined[4], []HistoryEvent{forced}).Awarded())
+}
+
+func TestParseDefinitionsErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"syntax", "{\n  \"achievements\": [\n    {\"name\": }\n  ]\n}", "bad.json:3: invalid json"},
+		{"truncated", "{\n  \"achievements\": [", "bad.json:2: invalid json"},
+		{"not an object", `[]`, "bad.json:1: expected an object"},
+		{"unknown top level", "{\n  \"achievments\": []\n}", `bad.json:2: unknown field "achievments"`},
+		{"missing name", "{\"achievements\": [\n  {\"first\": {\"command\": \"ls\"}}\n]}", "bad.json:2: missing name"},
+		{"name too long", "{\"achievements\": [\n  {\"name\": \"This name is far too long to fit\", \"first\": {\"command\": \"ls\"}}\n]}", "bad.json:2: name is too long"},
+		{"invalid id", "{\"achievements\": [\n  {\"id\": \"Ship it\", \"name\": \"ls\", \"first\": {\"command\": \"ls\"}}\n]}", `bad.json:2: invalid id "Ship it"`},
+		{"no id from name", "{\"achievements\": [\n  {\"name\": \"🐟\", \"first\": {\"command\": \"fish\"}}\n]}", "bad.json:2: missing id"},
+		{"tags not strings", "{\"achievements\": [{\"name\": \"ls\", \"tags\":\n  [1], \"first\": {\"command\": \"ls\"}}]}", "bad.json:2: expected a list of strings"},
+		{"missing first or nth", "{\"achievements\": [\n  {\"name\": \"ls\"}\n]}", "bad.json:2: missing first, nth, streak or sequence"},
+		{"both first and nth", "{\"achievements\": [{\"name\": \"ls\",\n  \"first\": {\"command\": \"ls\"},\n  \"nth\": {\"n\": 2, \"of\": {\"command\": \"ls\"}}}]}", "bad.json:3: only one of first, nth, streak and sequence"},
+		{"unknown condition", "{\"achievements\": [{\"name\": \"ls\", \"first\":\n  {\"commands\": \"ls\"}}]}", `bad.json:2: unknown condition "commands"`},
+		{"two keys", "{\"achievements\": [{\"name\": \"ls\", \"first\":\n  {\"command\": \"ls\", \"flag\": \"-l\"}}]}", "bad.json:2: a condition must be an object with exactly one key"},
+		{"bad subcommand", "{\"achievements\": [{\"name\": \"ls\", \"first\": {\"subcommand\":\n  [\"git\"]}}]}", "bad.json:2: subcommand must be a command and a subcommand"},
+		{"bad hour range", "{\"achievements\": [{\"name\": \"ls\", \"first\": {\"and\": [\n  {\"command\": \"ls\"},\n  {\"hour_range\": [5, 24]}]}}]}", "bad.json:3: invalid hour_range [5, 24]"},
+		{"nth zero", "{\"achievements\": [{\"name\": \"ls\", \"nth\": {\n  \"n\": 0, \"of\": {\"command\": \"ls\"}}}]}", "bad.json:2: n must be at least 1"},
+		{"nth not a number", "{\"achievements\": [{\"name\": \"ls\", \"nth\": {\n  \"n\": \"50\", \"of\": {\"command\": \"ls\"}}}]}", "bad.json:2: n must be a number"},
+		{"unknown period", "{\"achievements\": [{\"name\": \"ls\", \"streak\": {\"n\": 5,\n  \"period\": \"monthly\", \"of\": {\"command\": \"ls\"}}}]}", `bad.json:2: unknown period "monthly"`},
+		{"streak without of", "{\"achievements\": [{\"name\": \"ls\",\n  \"streak\": {\"n\": 5}}]}", "bad.json:2: missing of"},
+		{"empty sequence", "{\"achievements\": [{\"name\": \"ls\", \"sequence\": {\n  \"steps\": []}}]}", "bad.json:2: steps can't be empty"},
+		{"bad within", "{\"achievements\": [{\"name\": \"ls\", \"sequence\": {\"steps\": [{\"of\": {\"command\": \"ls\"},\n  \"within\": \"5 seconds\"}]}}]}", `bad.json:2: invalid within "5 seconds"`},
+		{"bad gap", "{\"achievements\": [{\"name\": \"ls\", \"sequence\": {\"steps\": [{\"of\": {\"command\": \"ls\"},\n  \"gap\": -2}]}}]}", `bad.json:2: gap must be a number of events, or "any"`},
+		{"unknown outcome", "{\"achievements\": [{\"name\": \"ls\", \"first\": {\n  \"outcome\": \"crash\"}}]}", `bad.json:2: unknown outcome "crash"`},
+		{"bad duration", "{\"achievements\": [{\"name\": \"ls\", \"first\": {\n  \"longer_than\": 5}}]}", "bad.json:2: longer_than must be a string"},
+		{"unknown rarity", "{\"achievements\": [{\"name\": \"ls\",\n  \"rarity\": \"mythic\", \"first\": {\"command\": \"ls\"}}]}", `bad.json:2: unknown rarity "mythic"`},
+		{"empty or", "{\"achievements\": [{\"name\": \"ls\", \"first\": {\n  \"or\": []}}]}", "bad.json:2: or can't be empty"},
+		{"empty exts", "{\"achievements\": [{\"name\": \"ls\", \"first\": {\n  \"exts\": []}}]}", "bad.json:2: exts can't be empty"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseDefinitions("bad.json", []byte(tc.data))
+			var errs DefinitionErrors
+			require.ErrorAs(t, err, &errs)
+			require.Len(t, errs, 1)
+			assert.Contains(t, errs[0].Error(), tc.err)
+		})
+	}
+}
+
+func TestParseDefinitionsKeepsValid(t *testing.T) {
+	data := `{"achievements": [
+  {"name": "ls", "first": {"command": "ls"}},
+  {"name": "broken", "first": {"command": 1}},
+  {"name": "cat", "first": {"command": "cat"}}
+]}`
+	defined, err := ParseDefinitions("mixed.json", []byte(data))
+	var errs DefinitionErrors
+	require.ErrorAs(t, err, &errs)
+	assert.Equal(t, "mixed.json:3: command must be a string", errs[0].Error())
+	require.Len(t, defined, 2)
+	assert.Equal(t, "ls", defined[0].Name)
+	assert.Equal(t, "cat", defined[1].Name)
+}
+
+func TestLoad(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	dir := path.Join(string(storagePath), definitionsDir)
+	require.NoError(t, os.MkdirAll(dir, 0777))
+	require.NoError(t, os.WriteFile(path.Join(dir, "ourctl.json"), []byte(ourctl), 0664))
+	require.NoError(t, os.WriteFile(path.Join(dir, "dupes.json"), []byte("{\"achievements\": [\n  {\"id\": \"go\", \"name\": \"Gopher again\", \"first\": {\"command\": \"go\"}}\n]}"), 0664))
+	require.NoError(t, os.WriteFile(path.Join(dir, "README.md"), []byte("not json"), 0664))
+
+	all, err := Load(storagePath)
+	var errs DefinitionErrors
+	require.ErrorAs(t, err, &errs)
+	require.Len(t, errs, 1)
+	assert.Equal(t, path.Join(dir, "dupes.json")+`:2: duplicate achievement "go"`, errs[0].Error())
+	assert.Len(t, all, len(Achievements)+5)
+
+	// packs added later catch up on what's in the wal
+	require.NoError(t, os.WriteFile(walPath(storagePath), []byte(`{"cmd":"ourctl","subcommand":"deploy","at":"2022-11-01T12:00:00Z"}`+"\n"), 0664))
+	engine, err := NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	require.NoError(t, engine.Update())
+
+	engine, err = NewEngine(storagePath, all)
+	require.NoError(t, err)
+	require.NoError(t, engine.Update())
+	assert.True(t, engine.Progress(all[len(Achievements)]).Awarded())
+	assert.Equal(t, 1, engine.Len())
+}
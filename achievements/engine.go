@@ -0,0 +1,688 @@
+package achievements
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+const snapshotVersion = 1
+
+// Snapshot is the persisted state of the Engine. It summarizes all events
+// that have been processed, including those that have been compacted out of
+// the wal.
+type Snapshot struct {
+	Version int `json:"version"`
+
+	// Until is when the wal was last compacted, events before it are
+	// summarized here and removed from the wal
+	Until  time.Time `json:"until"`
+	Events int       `json:"events"`
+
+	// Commands counts how many times each command was used
+	Commands map[string]int `json:"commands"`
+
+	// Achievements is the state of the evaluator of each achievement, by
+	// ID. Achievements from packs are prefixed with the name of the pack.
+	Achievements map[string]json.RawMessage `json:"achievements"`
+
+	// Quests is the state of the evaluator of each quest, by the day the
+	// quest started, its period and ID
+	Quests map[string]json.RawMessage `json:"quests,omitempty"`
+
+	// Badges are awarded for completed weekly challenges, and kept after
+	// the challenges are forgotten
+	Badges []Badge `json:"badges,omitempty"`
+
+	// Latest is when the latest processed event happened
+	Latest time.Time `json:"latest"`
+
+	// Base is the state of the evaluators as of Until, summarizing only the
+	// compacted events. It's nil until the wal is compacted.
+	Base *Base `json:"base,omitempty"`
+
+	// Offset is how much of the wal has been processed, and Checkpoint the
+	// last line that was processed. If the wal has been rotated, the offset is
+	// found again by looking for the checkpoint.
+	Offset     int64  `json:"offset"`
+	Checkpoint string `json:"checkpoint"`
+
+	Lines int `json:"lines"` // processed lines in the wal
+	Kept  int `json:"kept"`  // lines that were kept in the wal when it was compacted
+}
+
+// Base is the state of evaluators that have only seen the compacted events.
+// Evaluators only move forward in time, so when history is imported from
+// before events that have been processed they start over from it.
+type Base struct {
+	Achievements map[string]json.RawMessage `json:"achievements,omitempty"`
+	Quests       map[string]json.RawMessage `json:"quests,omitempty"`
+}
+
+func snapshotPath(storagePath state.StoragePath) string {
+	return path.Join(string(storagePath), "snapshot.json")
+}
+
+// LoadSnapshot reads the snapshot, an empty one is returned if nothing has
+// been processed yet
+func LoadSnapshot(storagePath state.StoragePath) (*Snapshot, error) {
+	contents, err := os.ReadFile(snapshotPath(storagePath))
+	if errors.Is(err, os.ErrNotExist) {
+		return &Snapshot{
+			Version:      snapshotVersion,
+			Commands:     map[string]int{},
+			Achievements: map[string]json.RawMessage{},
+		}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var s Snapshot
+	if err := json.Unmarshal(contents, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version: %d", s.Version)
+	}
+
+	if s.Commands == nil {
+		s.Commands = map[string]int{}
+	}
+	if s.Achievements == nil {
+		s.Achievements = map[string]json.RawMessage{}
+	}
+
+	return &s, nil
+}
+
+func (s *Snapshot) save(storagePath state.StoragePath) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+	if err := writeFileAtomic(snapshotPath(storagePath), data); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// Engine evaluates achievements one event at a time. Its state is saved to
+// the storage path, so that only events that have been appended to the wal
+// since the last run have to be read.
+type Engine struct {
+	storagePath  state.StoragePath
+	snapshot     *Snapshot
+	achievements []Achievement
+	evaluators   map[string]Evaluator
+
+	// the same evaluators, as iterating over a map is slow
+	ordered []Evaluator
+
+	// evaluators without saved state, that haven't seen the events in the
+	// wal yet
+	fresh []Evaluator
+
+	// quests that are tracked, see TrackQuests
+	quests  map[string]Evaluator
+	tracked []Quest
+
+	// called with each new event, see Subscribe
+	subscribers []func(HistoryEvent)
+
+	calendar Calendar
+}
+
+// NewEngine restores the engine for the achievements from the storage path,
+// call Update to process new events
+func NewEngine(storagePath state.StoragePath, achievements []Achievement) (*Engine, error) {
+	snapshot, err := LoadSnapshot(storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	evaluators := make(map[string]Evaluator, len(achievements))
+	ordered := make([]Evaluator, 0, len(achievements))
+	var fresh []Evaluator
+	for _, a := range achievements {
+		ev := a.Func()
+		if raw, ok := snapshot.Achievements[a.Key()]; ok {
+			if err := json.Unmarshal(raw, ev); err != nil {
+				return nil, fmt.Errorf("failed to restore %q: %w", a.Key(), err)
+			}
+		} else {
+			fresh = append(fresh, ev)
+		}
+		evaluators[a.Key()] = ev
+		ordered = append(ordered, ev)
+	}
+
+	return &Engine{
+		storagePath:  storagePath,
+		snapshot:     snapshot,
+		achievements: achievements,
+		evaluators:   evaluators,
+		ordered:      ordered,
+		fresh:        fresh,
+	}, nil
+}
+
+// Update processes the events that have been appended to the wal since the
+// last update, and saves the state. If some of the new lines are corrupt, they
+// are skipped and returned as a *CorruptError.
+func (e *Engine) Update() error {
+	// don't read while a command is being written
+	file, err := state.OpenLocked(walPath(e.storagePath), os.O_RDONLY, 0, false)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read wal: %w", err)
+	}
+	defer file.Close()
+
+	return e.update(file)
+}
+
+func (e *Engine) update(file *os.File) error {
+	if err := e.seek(file); err != nil {
+		return err
+	}
+
+	replayed, err := e.replay(file)
+	if err != nil {
+		return err
+	}
+
+	var events []HistoryEvent
+	var corrupt []CorruptLine
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a line without a newline was torn when it was written, and
+			// will be reported as corrupt when the next line is written
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read wal: %w", err)
+		}
+
+		e.snapshot.Offset += int64(len(line))
+		e.snapshot.Checkpoint = string(line)
+		e.snapshot.Lines++
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var event HistoryEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			corrupt = append(corrupt, CorruptLine{Line: e.snapshot.Lines, Err: err})
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if len(events) > 0 || len(corrupt) > 0 || replayed {
+		sort.SliceStable(events, func(a, b int) bool {
+			return events[a].At.Before(events[b].At)
+		})
+
+		// Imported history is appended after newer events, which the
+		// evaluators have seen already
+		if e.imported(events) {
+			if err := e.rebuild(file); err != nil {
+				return err
+			}
+			e.add(events, nil)
+		} else {
+			e.add(events, e.ordered)
+		}
+
+		if err := e.save(); err != nil {
+			return err
+		}
+	}
+
+	if len(corrupt) > 0 {
+		return &CorruptError{Lines: corrupt}
+	}
+	return nil
+}
+
+// seek moves to where the last update stopped. If the wal has been rotated
+// since, the checkpoint is looked for, and if it can't be found the wal is
+// read from the start.
+func (e *Engine) seek(file *os.File) error {
+	s := e.snapshot
+	if s.Offset > 0 {
+		checkpoint := make([]byte, len(s.Checkpoint))
+		if _, err := file.ReadAt(checkpoint, s.Offset-int64(len(checkpoint))); err == nil && string(checkpoint) == s.Checkpoint {
+			if _, err := file.Seek(s.Offset, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to seek wal: %w", err)
+			}
+			return nil
+		}
+	}
+
+	var offset int64
+	var lines int
+	found := false
+
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read wal: %w", err)
+		}
+		offset += int64(len(line))
+		lines++
+		if s.Checkpoint != "" && string(line) == s.Checkpoint {
+			s.Offset, s.Lines, found = offset, lines, true
+		}
+	}
+	if !found {
+		s.Offset, s.Lines = 0, 0
+	}
+
+	if _, err := file.Seek(s.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek wal: %w", err)
+	}
+	return nil
+}
+
+// replay lets evaluators without saved state, like achievements from a newly
+// installed pack, catch up on the events that have been processed already.
+// Only what's left in the wal can be replayed, compacted events are lost to
+// them.
+func (e *Engine) replay(file *os.File) (bool, error) {
+	if len(e.fresh) == 0 {
+		return false, nil
+	}
+	defer func() {
+		e.fresh = nil
+	}()
+
+	if e.snapshot.Offset == 0 {
+		return false, nil
+	}
+
+	events, _, err := readEvents(io.NewSectionReader(file, 0, e.snapshot.Offset))
+	if err != nil {
+		return false, err
+	}
+
+	for _, event := range events {
+		if event.At.Before(e.snapshot.Until) {
+			continue
+		}
+		for _, ev := range e.fresh {
+			ev.Add(event)
+		}
+	}
+	return true, nil
+}
+
+// imported is true if some of the sorted events happened before the latest
+// event that has been processed
+func (e *Engine) imported(events []HistoryEvent) bool {
+	for _, event := range events {
+		if !event.At.Before(e.snapshot.Until) {
+			return event.At.Before(e.snapshot.Latest)
+		}
+	}
+	return false
+}
+
+// rebuild replaces the evaluators with ones that start over from the base,
+// and have seen the events in the wal up to the offset in the order they
+// happened
+func (e *Engine) rebuild(file *os.File) error {
+	events, _, err := readEvents(io.NewSectionReader(file, 0, e.snapshot.Offset))
+	if err != nil {
+		return err
+	}
+
+	evaluators, quests, err := e.restore(e.snapshot.Base)
+	if err != nil {
+		return err
+	}
+	ordered := flatten(evaluators, quests)
+	for _, event := range events {
+		if event.At.Before(e.snapshot.Until) {
+			continue
+		}
+		for _, ev := range ordered {
+			ev.Add(event)
+		}
+	}
+
+	e.evaluators, e.quests, e.ordered = evaluators, quests, ordered
+	return nil
+}
+
+// restore creates the evaluators of the achievements and tracked quests, by
+// key, with the state that they have in the base
+func (e *Engine) restore(base *Base) (map[string]Evaluator, map[string]Evaluator, error) {
+	if base == nil {
+		base = &Base{}
+	}
+
+	evaluators := make(map[string]Evaluator, len(e.achievements))
+	for _, a := range e.achievements {
+		ev := a.Func()
+		if raw, ok := base.Achievements[a.Key()]; ok {
+			if err := json.Unmarshal(raw, ev); err != nil {
+				return nil, nil, fmt.Errorf("failed to restore %q: %w", a.Key(), err)
+			}
+		}
+		evaluators[a.Key()] = ev
+	}
+
+	quests := make(map[string]Evaluator, len(e.tracked))
+	for _, q := range e.tracked {
+		ev := q.Func()
+		if raw, ok := base.Quests[q.key()]; ok {
+			if err := json.Unmarshal(raw, ev); err != nil {
+				return nil, nil, fmt.Errorf("failed to restore quest %q: %w", q.key(), err)
+			}
+		}
+		quests[q.key()] = ev
+	}
+
+	for _, ev := range flatten(evaluators, quests) {
+		if s, ok := ev.(interface{ setCalendar(Calendar) }); ok {
+			s.setCalendar(e.calendar)
+		}
+	}
+	return evaluators, quests, nil
+}
+
+// flatten lists the evaluators, as iterating over maps is slow
+func flatten(evaluators ...map[string]Evaluator) []Evaluator {
+	var res []Evaluator
+	for _, m := range evaluators {
+		for _, ev := range m {
+			res = append(res, ev)
+		}
+	}
+	return res
+}
+
+// base saves the state of the evaluators as a base
+func (e *Engine) base(evaluators, quests map[string]Evaluator) (*Base, error) {
+	base := &Base{
+		Achievements: make(map[string]json.RawMessage, len(evaluators)),
+		Quests:       make(map[string]json.RawMessage, len(quests)),
+	}
+	if err := marshalEvaluators(evaluators, base.Achievements); err != nil {
+		return nil, err
+	}
+	if err := marshalEvaluators(quests, base.Quests); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// add folds sorted events into the evaluators, and passes them on to the
+// subscribers
+func (e *Engine) add(events []HistoryEvent, evaluators []Evaluator) {
+	for _, event := range events {
+		// compacted events can't be counted again
+		if event.At.Before(e.snapshot.Until) {
+			continue
+		}
+
+		e.snapshot.Commands[event.Cmd]++
+		e.snapshot.Events++
+		if event.At.After(e.snapshot.Latest) {
+			e.snapshot.Latest = event.At
+		}
+		for _, ev := range evaluators {
+			ev.Add(event)
+		}
+		for _, fn := range e.subscribers {
+			fn(event)
+		}
+	}
+}
+
+func (e *Engine) save() error {
+	if err := marshalEvaluators(e.evaluators, e.snapshot.Achievements); err != nil {
+		return err
+	}
+	if err := marshalEvaluators(e.quests, e.snapshot.Quests); err != nil {
+		return err
+	}
+	e.awardBadges()
+	return e.snapshot.save(e.storagePath)
+}
+
+// marshalEvaluators saves the state of the evaluators into raw, by key
+func marshalEvaluators(evaluators map[string]Evaluator, raw map[string]json.RawMessage) error {
+	for key, ev := range evaluators {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %q: %w", key, err)
+		}
+		raw[key] = data
+	}
+	return nil
+}
+
+// Len is the number of processed events
+func (e *Engine) Len() int {
+	return e.snapshot.Events
+}
+
+// Appended is the number of lines that have been appended to the wal since it
+// was compacted
+func (e *Engine) Appended() int {
+	return e.snapshot.Lines - e.snapshot.Kept
+}
+
+// Progress returns the progress of the achievement
+func (e *Engine) Progress(a Achievement) Progress {
+	if ev, ok := e.evaluators[a.Key()]; ok {
+		return ev.Progress()
+	}
+	return Progress{}
+}
+
+// SetCalendar sets when days start for streaks, it has to be called before
+// Update to take effect for the new events
+func (e *Engine) SetCalendar(calendar Calendar) {
+	e.calendar = calendar
+	for _, ev := range e.ordered {
+		if s, ok := ev.(interface{ setCalendar(Calendar) }); ok {
+			s.setCalendar(calendar)
+		}
+	}
+}
+
+// Calendar returns the calendar set with SetCalendar
+func (e *Engine) Calendar() Calendar {
+	return e.calendar
+}
+
+// Subscribe calls fn with each new event that is processed by Update, in
+// the order they happened. Events that are replayed for new evaluators aren't
+// new, and aren't passed to it.
+func (e *Engine) Subscribe(fn func(HistoryEvent)) {
+	e.subscribers = append(e.subscribers, fn)
+}
+
+// Streak returns the streak of the achievement as of now, if it's a streak
+func (e *Engine) Streak(a Achievement, now time.Time) (Streak, bool) {
+	if s, ok := e.evaluators[a.Key()].(*streakCounter); ok {
+		return s.Streak(now), true
+	}
+	return Streak{}, false
+}
+
+// Achievements returns all achievements that are evaluated
+func (e *Engine) Achievements() []Achievement {
+	return e.achievements
+}
+
+// Awarded returns the achievements that have been awarded, with AwardedAt set
+func (e *Engine) Awarded() []Achievement {
+	var awarded []Achievement
+	for _, a := range e.achievements {
+		if p := e.Progress(a); p.Awarded() {
+			a.AwardedAt = *p.AwardedAt
+			awarded = append(awarded, a)
+		}
+	}
+	return awarded
+}
+
+// Compact processes all events in the wal, and then rotates it to only keep
+// the events that happened after the time. It returns the number of lines that
+// were removed, corrupt lines are removed as well. As with Update, new corrupt
+// lines are returned as a *CorruptError.
+func (e *Engine) Compact(before time.Time) (int, error) {
+	// writers are held off until the new wal is in place
+	file, err := state.OpenLocked(walPath(e.storagePath), os.O_RDONLY, 0, true)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to open wal: %w", err)
+	}
+	defer file.Close()
+
+	var corrupt *CorruptError
+	if err := e.update(file); err != nil && !errors.As(err, &corrupt) {
+		return 0, err
+	}
+
+	if !before.After(e.snapshot.Until) {
+		return 0, corruptOrNil(corrupt)
+	}
+
+	// The base moves up to before, and nothing before it can be imported
+	// from now on. It's saved before the wal is rotated to not let anything
+	// slip through.
+	if err := e.advance(file, before); err != nil {
+		return 0, err
+	}
+	e.snapshot.Until = before
+	if err := e.save(); err != nil {
+		return 0, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek wal: %w", err)
+	}
+
+	// The lines that are kept are copied as they are, in the same order, and
+	// the last processed line is always kept. Should this be interrupted
+	// before the snapshot is saved again, the checkpoint is found in the new
+	// wal.
+	var wal bytes.Buffer
+	var removed, kept int
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for offset := int64(0); offset < e.snapshot.Offset; {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return 0, fmt.Errorf("failed to read wal: %w", err)
+		}
+		offset += int64(len(line))
+
+		var event HistoryEvent
+		last := offset == e.snapshot.Offset
+		if err := json.Unmarshal(line, &event); (err == nil && !event.At.Before(before)) || last {
+			wal.Write(line)
+			kept++
+		} else {
+			removed++
+		}
+	}
+
+	if err := writeFileAtomic(walPath(e.storagePath), wal.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to rotate wal: %w", err)
+	}
+
+	e.snapshot.Offset = int64(wal.Len())
+	e.snapshot.Lines = kept
+	e.snapshot.Kept = kept
+	if err := e.save(); err != nil {
+		return 0, err
+	}
+
+	return removed, corruptOrNil(corrupt)
+}
+
+// advance moves the base up to before, with the events in the wal that are
+// about to be compacted
+func (e *Engine) advance(file *os.File, before time.Time) error {
+	events, _, err := readEvents(io.NewSectionReader(file, 0, e.snapshot.Offset))
+	if err != nil {
+		return err
+	}
+
+	evaluators, quests, err := e.restore(e.snapshot.Base)
+	if err != nil {
+		return err
+	}
+	ordered := flatten(evaluators, quests)
+	for _, event := range events {
+		if event.At.Before(e.snapshot.Until) || !event.At.Before(before) {
+			continue
+		}
+		for _, ev := range ordered {
+			ev.Add(event)
+		}
+	}
+
+	base, err := e.base(evaluators, quests)
+	if err != nil {
+		return err
+	}
+	e.snapshot.Base = base
+	return nil
+}
+
+func corruptOrNil(err *CorruptError) error {
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
+// writeFileAtomic replaces the file, so that readers see either the old or
+// the new contents
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(path.Dir(name), path.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0664); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
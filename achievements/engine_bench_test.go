@@ -0,0 +1,69 @@
+//go:build bench
+
+package achievements
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+// The benchmarks write a lot of history, run them with:
+//
+//	go test -tags bench -run '^$' -bench . ./achievements
+
+// BenchmarkLoadHistory loads a million events, as if marblezero had been used
+// for a few years
+func BenchmarkLoadHistory(b *testing.B) {
+	const events = 1000000
+	start := time.Now().Add(-events * time.Minute)
+
+	storagePath := state.StoragePath(b.TempDir())
+	appendSyntheticHistory(b, storagePath, start, events)
+
+	b.Run("first run", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			require.NoError(b, os.RemoveAll(snapshotPath(storagePath)))
+			b.StartTimer()
+
+			engine := updatedEngine(b, storagePath)
+			if engine.Len() != events {
+				b.Fatalf("expected %d events, got %d", events, engine.Len())
+			}
+			engine.Awarded()
+		}
+	})
+
+	b.Run("new events", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			appendSyntheticHistory(b, storagePath, time.Now(), 100)
+			b.StartTimer()
+
+			engine := updatedEngine(b, storagePath)
+			if engine.Len() <= events {
+				b.Fatalf("expected more than %d events, got %d", events, engine.Len())
+			}
+			engine.Awarded()
+		}
+	})
+
+	b.Run("compacted", func(b *testing.B) {
+		engine := updatedEngine(b, storagePath)
+		_, err := engine.Compact(time.Now().Add(-30 * 24 * time.Hour))
+		require.NoError(b, err)
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			appendSyntheticHistory(b, storagePath, time.Now(), 100)
+			b.StartTimer()
+
+			updatedEngine(b, storagePath).Awarded()
+		}
+	})
+}
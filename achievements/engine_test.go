@@ -0,0 +1,305 @@
+package achievements
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+var syntheticCommands = []HistoryEvent{
+	{Cmd: "git", SubCommand: "commit"},
+	{Cmd: "git", SubCommand: "add", FileExtensions: []string{"go", "md", "py"}},
+	{Cmd: "go", SubCommand: "test"},
+	{Cmd: "docker", SubCommand: "compose up"},
+	{Cmd: "kubectl", SubCommand: "get"},
+	{Cmd: "python3"},
+	{Cmd: "cargo", SubCommand: "build"},
+	{Cmd: "ls"},
+	{Cmd: "vim"},
+	{Cmd: "rm", Flags: []string{"-rf"}, IsRmRf: true},
+}
+
+// appendSyntheticHistory appends n events to the wal, one every minute
+// starting at the time
+func appendSyntheticHistory(t testing.TB, storagePath state.StoragePath, start time.Time, n int) {
+	fp, err := os.OpenFile(walPath(storagePath), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	w := bufio.NewWriter(fp)
+	for i := 0; i < n; i++ {
+		e := syntheticCommands[i%len(syntheticCommands)]
+		e.At = start.Add(time.Duration(i) * time.Minute)
+		raw, err := json.Marshal(e)
+		require.NoError(t, err)
+		w.Write(raw)
+		w.WriteByte('\n')
+	}
+	require.NoError(t, w.Flush())
+}
+
+func updatedEngine(t testing.TB, storagePath state.StoragePath) *Engine {
+	engine, err := NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	require.NoError(t, engine.Update())
+	return engine
+}
+
+func awardedAt(e *Engine) map[string]time.Time {
+	res := make(map[string]time.Time)
+	for _, a := range e.Awarded() {
+		res[a.Name] = a.AwardedAt
+	}
+	return res
+}
+
+func TestEngine(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+
+	appendSyntheticHistory(t, storagePath, start, 100)
+	engine := updatedEngine(t, storagePath)
+	assert.Equal(t, 100, engine.Len())
+	assert.Equal(t, Progress{Count: 10, Target: 50}, engine.Progress(achievement(t, "Developer")))
+
+	// the 50th commit happens on the 491st event
+	appendSyntheticHistory(t, storagePath, start.Add(100*time.Minute), 400)
+	engine = updatedEngine(t, storagePath)
+	assert.Equal(t, 500, engine.Len())
+
+	progress := engine.Progress(achievement(t, "Developer"))
+	assert.Equal(t, 50, progress.Count)
+	require.True(t, progress.Awarded())
+	assert.True(t, start.Add(490*time.Minute).Equal(*progress.AwardedAt))
+
+	// nothing new, nothing changes
+	engine = updatedEngine(t, storagePath)
+	assert.Equal(t, 500, engine.Len())
+	assert.Equal(t, progress, engine.Progress(achievement(t, "Developer")))
+}
+
+func TestEngineOnlyReadsNewLines(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+
+	appendSyntheticHistory(t, storagePath, start, 10)
+	updatedEngine(t, storagePath)
+
+	// lines that have been processed are never read again
+	wal, err := os.ReadFile(walPath(storagePath))
+	require.NoError(t, err)
+	for i := range wal[:len(wal)/2] {
+		wal[i] = 'x'
+	}
+	require.NoError(t, os.WriteFile(walPath(storagePath), wal, 0664))
+
+	appendSyntheticHistory(t, storagePath, start.Add(10*time.Minute), 10)
+	engine := updatedEngine(t, storagePath)
+	assert.Equal(t, 20, engine.Len())
+}
+
+func TestSubscribe(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	appendSyntheticHistory(t, storagePath, start, 10)
+
+	// only new events are passed on, in order
+	var seen []time.Time
+	engine, err := NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	engine.Subscribe(func(e HistoryEvent) {
+		seen = append(seen, e.At)
+	})
+	require.NoError(t, engine.Update())
+	appendSyntheticHistory(t, storagePath, start.Add(10*time.Minute), 5)
+	require.NoError(t, engine.Update())
+	require.Len(t, seen, 15)
+	assert.True(t, sort.SliceIsSorted(seen, func(a, b int) bool { return seen[a].Before(seen[b]) }))
+
+	seen = nil
+	engine, err = NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	engine.Subscribe(func(e HistoryEvent) {
+		seen = append(seen, e.At)
+	})
+	require.NoError(t, engine.TrackQuests([]Quest{{ID: "ls", Period: Daily, Start: start, Func: first(withCommand("ls"))}}))
+	require.NoError(t, engine.Update())
+	assert.Empty(t, seen, "replayed events aren't new")
+}
+
+func TestCompact(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	appendSyntheticHistory(t, storagePath, start, 5000)
+
+	before := updatedEngine(t, storagePath)
+
+	removed, err := before.Compact(start.Add(3000 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 3000, removed)
+	assert.Equal(t, 0, before.Appended())
+
+	wal, err := ParseHistory(storagePath)
+	require.NoError(t, err)
+	assert.Len(t, wal, 2000)
+
+	after := updatedEngine(t, storagePath)
+	assert.Equal(t, 5000, after.Len())
+	assert.Equal(t, 500, after.snapshot.Commands["ls"])
+	assert.Equal(t, awardedAt(before), awardedAt(after))
+
+	// new events after compaction are picked up
+	appendSyntheticHistory(t, storagePath, start.Add(5000*time.Minute), 10)
+	after = updatedEngine(t, storagePath)
+	assert.Equal(t, 5010, after.Len())
+	assert.Equal(t, 10, after.Appended())
+
+	// compacting again, up to the same time, does nothing
+	removed, err = after.Compact(start.Add(3000 * time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+}
+
+func TestCompactKeepsCheckpoint(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	appendSyntheticHistory(t, storagePath, start, 100)
+
+	engine := updatedEngine(t, storagePath)
+	snapshot, err := os.ReadFile(snapshotPath(storagePath))
+	require.NoError(t, err)
+
+	// everything is old, but the last line is kept to find where to continue
+	removed, err := engine.Compact(start.Add(time.Hour * 24))
+	require.NoError(t, err)
+	assert.Equal(t, 99, removed)
+
+	// as if compaction was interrupted after the wal was rotated
+	snapshot = []byte(strings.Replace(string(snapshot), `"until":"0001-01-01T00:00:00Z"`, `"until":"`+start.Add(time.Hour*24).Format(time.RFC3339Nano)+`"`, 1))
+	require.NoError(t, os.WriteFile(snapshotPath(storagePath), snapshot, 0664))
+
+	appendSyntheticHistory(t, storagePath, start.Add(24*time.Hour), 10)
+	engine = updatedEngine(t, storagePath)
+	assert.Equal(t, 110, engine.Len())
+}
+
+func TestImportAfterFirstLaunch(t *testing.T) {
+	today := time.Date(2022, 11, 11, 12, 0, 0, 0, time.Local)
+	daily := achievement(t, "Creature of habit")
+	gopher := achievement(t, "Gopher")
+
+	days := func(from, to int) []HistoryEvent {
+		var res []HistoryEvent
+		for i := from; i < to; i++ {
+			res = append(res, HistoryEvent{Cmd: "go", SubCommand: "build", At: today.AddDate(0, 0, i)})
+		}
+		return res
+	}
+
+	t.Run("before the first event", func(t *testing.T) {
+		storagePath := state.StoragePath(t.TempDir())
+		appendEvents(t, storagePath, HistoryEvent{Cmd: "ls", At: today})
+		updatedEngine(t, storagePath)
+
+		appendEvents(t, storagePath, days(-10, 0)...)
+		engine := updatedEngine(t, storagePath)
+		assert.Equal(t, 11, engine.Len())
+		assert.True(t, engine.Progress(daily).Awarded())
+		streak, _ := engine.Streak(daily, today)
+		assert.Equal(t, Streak{Period: Daily, Current: 11, Longest: 11}, streak)
+		require.True(t, engine.Progress(gopher).Awarded())
+		assert.True(t, today.AddDate(0, 0, -10).Equal(*engine.Progress(gopher).AwardedAt))
+
+		// the same as reading it all at once
+		all := state.StoragePath(t.TempDir())
+		appendEvents(t, all, days(-10, 0)...)
+		appendEvents(t, all, HistoryEvent{Cmd: "ls", At: today})
+		assert.Equal(t, awardedAt(updatedEngine(t, all)), awardedAt(engine))
+	})
+
+	t.Run("after compacting", func(t *testing.T) {
+		storagePath := state.StoragePath(t.TempDir())
+		appendEvents(t, storagePath, days(-10, -5)...)
+		appendEvents(t, storagePath, HistoryEvent{Cmd: "ls", At: today})
+		engine := updatedEngine(t, storagePath)
+		_, err := engine.Compact(today.AddDate(0, 0, -7))
+		require.NoError(t, err)
+
+		// the compacted days still count
+		appendEvents(t, storagePath, days(-5, 0)...)
+		engine = updatedEngine(t, storagePath)
+		assert.Equal(t, 11, engine.Len())
+		assert.True(t, engine.Progress(daily).Awarded())
+		streak, _ := engine.Streak(daily, today)
+		assert.Equal(t, Streak{Period: Daily, Current: 11, Longest: 11}, streak)
+		assert.True(t, today.AddDate(0, 0, -10).Equal(*engine.Progress(gopher).AwardedAt))
+	})
+}
+
+func TestCorruptLines(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	appendSyntheticHistory(t, storagePath, start, 10)
+
+	fp, err := os.OpenFile(walPath(storagePath), os.O_APPEND|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	_, err = fp.WriteString("{\"cmd\":\n")
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+	appendSyntheticHistory(t, storagePath, start.Add(10*time.Minute), 10)
+
+	engine, err := NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+
+	var corrupt *CorruptError
+	require.ErrorAs(t, engine.Update(), &corrupt)
+	require.Len(t, corrupt.Lines, 1)
+	assert.Equal(t, 11, corrupt.Lines[0].Line)
+	assert.Equal(t, 20, engine.Len())
+
+	// it's only reported once
+	assert.NoError(t, updatedEngine(t, storagePath).Update())
+
+	// all but the last line
+	removed, err := engine.Compact(start.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 20, removed)
+}
+
+func TestUnsupportedSnapshotVersion(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	require.NoError(t, os.WriteFile(snapshotPath(storagePath), []byte(`{"version":2,"events":10}`), 0664))
+
+	_, err := NewEngine(storagePath, Achievements)
+	assert.EqualError(t, err, "unsupported snapshot version: 2")
+}
+
+func TestBuiltinIDs(t *testing.T) {
+	seen := map[string]string{}
+	for _, a := range Achievements {
+		assert.Regexp(t, idPattern, a.ID, a.Name)
+		assert.NotEmpty(t, a.Category, a.Name)
+		if other, ok := seen[a.ID]; ok {
+			t.Errorf("%q and %q have the same id %q", other, a.Name, a.ID)
+		}
+		seen[a.ID] = a.Name
+	}
+}
+
+func achievement(t testing.TB, name string) Achievement {
+	for _, a := range Achievements {
+		if a.Name == name {
+			return a
+		}
+	}
+	t.Fatalf("no achievement named %q", name)
+	return Achievement{}
+}
@@ -0,0 +1,326 @@
+package achievements
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+const (
+	// packsDir is where packs are installed in the storage path, each in a
+	// directory of its own
+	packsDir = "packs"
+
+	// manifestFile is the file describing a pack, and its achievements
+	manifestFile = "pack.json"
+
+	// originFile is where an installed pack was installed from, to be able
+	// to upgrade it
+	originFile = "origin"
+)
+
+var (
+	packNamePattern    = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	packVersionPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+)
+
+// Pack is a named and versioned bundle of achievements, that can be shared
+// by a team. A pack is a directory with a pack.json manifest:
+//
+//	{
+//	  "name": "ourteam",
+//	  "version": "1.2.0",
+//	  "author": "Platform team",
+//	  "achievements": [...]
+//	}
+//
+// The achievements are defined as described by ParseDefinitions. Progress is
+// kept by the name of the pack and the achievement, so it survives upgrades.
+type Pack struct {
+	Name        string
+	Version     string
+	Author      string
+	Description string
+
+	// Origin is the path or git url the pack was installed from
+	Origin string
+
+	Achievements []Achievement
+
+	manifest []byte
+}
+
+// ParsePack parses a pack manifest, and validates it and its achievements
+func ParsePack(file string, data []byte) (*Pack, error) {
+	l := &loader{file: file, data: data}
+
+	root, ok := l.root()
+	if !ok {
+		return nil, l.errs
+	}
+	fields, ok := l.object(root, "name", "version", "author", "description", "achievements")
+	if !ok {
+		return nil, l.errs
+	}
+
+	pack := Pack{manifest: data}
+	if pack.Name, ok = l.str(fields["name"], root, "name"); ok && !packNamePattern.MatchString(pack.Name) {
+		l.errorf(fields["name"].offset, "invalid name %q, only lowercase letters, digits, - and _ are allowed", pack.Name)
+	}
+	if pack.Version, ok = l.str(fields["version"], root, "version"); ok && !packVersionPattern.MatchString(pack.Version) {
+		l.errorf(fields["version"].offset, "invalid version %q, expected something like 1.2.0", pack.Version)
+	}
+	pack.Author, _ = l.str(fields["author"], root, "author")
+	if desc := fields["description"]; desc != nil {
+		pack.Description, _ = l.str(desc, root, "description")
+	}
+
+	pack.Achievements = l.achievements(fields["achievements"], root)
+	for i := range pack.Achievements {
+		pack.Achievements[i].Pack = pack.Name
+	}
+	var dupErrs DefinitionErrors
+	pack.Achievements, dupErrs = dedupeAchievements(pack.Achievements)
+	l.errs = append(l.errs, dupErrs...)
+
+	if len(l.errs) > 0 {
+		return &pack, l.errs
+	}
+	return &pack, nil
+}
+
+// InstalledPacks returns the packs that are installed, sorted by name.
+// Packs with invalid achievements are returned with the valid ones, together
+// with DefinitionErrors.
+func InstalledPacks(storagePath state.StoragePath) ([]*Pack, error) {
+	manifests, err := filepath.Glob(path.Join(string(storagePath), packsDir, "*", manifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packs: %w", err)
+	}
+	sort.Strings(manifests)
+
+	var packs []*Pack
+	var errs DefinitionErrors
+	for _, manifest := range manifests {
+		pack, err := readPack(manifest)
+		var defErrs DefinitionErrors
+		if errors.As(err, &defErrs) {
+			errs = append(errs, defErrs...)
+			if pack == nil {
+				continue
+			}
+		} else if err != nil {
+			return nil, err
+		}
+
+		origin, err := os.ReadFile(path.Join(path.Dir(manifest), originFile))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read origin of pack: %w", err)
+		}
+		pack.Origin = strings.TrimSpace(string(origin))
+
+		packs = append(packs, pack)
+	}
+
+	if len(errs) > 0 {
+		return packs, errs
+	}
+	return packs, nil
+}
+
+func readPack(manifest string) (*Pack, error) {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack: %w", err)
+	}
+	return ParsePack(manifest, data)
+}
+
+// InstallPack installs a pack from a directory, a pack.json file or a git
+// repository. A branch or tag of the repository can be selected by adding it
+// to the url, like https://github.com/ourteam/marblezero-pack.git#v1.2.0.
+func InstallPack(storagePath state.StoragePath, origin string) (*Pack, error) {
+	pack, origin, err := fetchPack(origin)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(packDir(storagePath, pack.Name)); err == nil {
+		return nil, fmt.Errorf("pack %q is already installed, upgrade it instead", pack.Name)
+	}
+
+	if err := writePack(storagePath, pack, origin); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// UpgradePack installs the latest version of a pack from where it was
+// installed from. The installed pack is returned if it's already up to date.
+// A pack with a manifest that can't be read at all is installed again, and
+// nil is returned for the installed pack.
+func UpgradePack(storagePath state.StoragePath, name string) (installed, upgraded *Pack, err error) {
+	if err := checkPackName(name); err != nil {
+		return nil, nil, err
+	}
+	installed, err = readPack(path.Join(packDir(storagePath, name), manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, fmt.Errorf("pack %q is not installed", name)
+	}
+	var defErrs DefinitionErrors
+	if err != nil && !errors.As(err, &defErrs) {
+		return nil, nil, err
+	}
+
+	origin, err := os.ReadFile(path.Join(packDir(storagePath, name), originFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read origin of pack: %w", err)
+	}
+
+	upgraded, _, err = fetchPack(strings.TrimSpace(string(origin)))
+	if err != nil {
+		return nil, nil, err
+	}
+	if upgraded.Name != name {
+		return nil, nil, fmt.Errorf("pack at %s is now named %q, remove %q and install it again", origin, upgraded.Name, name)
+	}
+	if installed != nil && compareVersions(upgraded.Version, installed.Version) <= 0 {
+		return installed, installed, nil
+	}
+
+	if err := writePack(storagePath, upgraded, strings.TrimSpace(string(origin))); err != nil {
+		return nil, nil, err
+	}
+	return installed, upgraded, nil
+}
+
+// RemovePack uninstalls a pack. The progress of its achievements is kept, in
+// case it's installed again.
+func RemovePack(storagePath state.StoragePath, name string) error {
+	if err := checkPackName(name); err != nil {
+		return err
+	}
+	dir := packDir(storagePath, name)
+	if _, err := os.Stat(path.Join(dir, manifestFile)); err != nil {
+		return fmt.Errorf("pack %q is not installed", name)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove pack: %w", err)
+	}
+	return nil
+}
+
+// checkPackName makes sure that a name from the user is a name that a pack
+// can have, and not a path out of the packs directory
+func checkPackName(name string) error {
+	if !packNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid pack name %q, only lowercase letters, digits, - and _ are allowed", name)
+	}
+	return nil
+}
+
+func packDir(storagePath state.StoragePath, name string) string {
+	return path.Join(string(storagePath), packsDir, name)
+}
+
+// fetchPack reads and validates a pack, and returns it with the absolute
+// origin. Packs with any errors are refused.
+func fetchPack(origin string) (*Pack, string, error) {
+	if isGitURL(origin) {
+		dir, err := os.MkdirTemp("", "marblezero-pack-")
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create temporary directory: %w", err)
+		}
+		defer os.RemoveAll(dir)
+
+		if err := gitClone(origin, dir); err != nil {
+			return nil, "", err
+		}
+		pack, err := readPack(path.Join(dir, manifestFile))
+		if err != nil {
+			return nil, "", err
+		}
+		return pack, origin, nil
+	}
+
+	abs, err := filepath.Abs(origin)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid path: %w", err)
+	}
+	manifest := abs
+	if info, err := os.Stat(abs); err == nil && info.IsDir() {
+		manifest = path.Join(abs, manifestFile)
+	}
+	pack, err := readPack(manifest)
+	if err != nil {
+		return nil, "", err
+	}
+	return pack, abs, nil
+}
+
+func writePack(storagePath state.StoragePath, pack *Pack, origin string) error {
+	dir := packDir(storagePath, pack.Name)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return fmt.Errorf("failed to create pack directory: %w", err)
+	}
+	if err := writeFileAtomic(path.Join(dir, manifestFile), pack.manifest); err != nil {
+		return fmt.Errorf("failed to install pack: %w", err)
+	}
+	if err := writeFileAtomic(path.Join(dir, originFile), []byte(origin+"\n")); err != nil {
+		return fmt.Errorf("failed to install pack: %w", err)
+	}
+	return nil
+}
+
+func isGitURL(origin string) bool {
+	return strings.Contains(origin, "://") || strings.HasPrefix(origin, "git@") || strings.HasSuffix(strings.SplitN(origin, "#", 2)[0], ".git")
+}
+
+func gitClone(origin, dir string) error {
+	url, ref, _ := strings.Cut(origin, "#")
+
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, "--", url, dir)
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to clone %s: %w: %s", url, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// compareVersions compares dotted versions like 1.2.0 part by part
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
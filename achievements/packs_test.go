@@ -0,0 +1,178 @@
+package achievements
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+func writeManifest(t *testing.T, dir, version string, achievements string) {
+	manifest := fmt.Sprintf(`{
+  "name": "ourteam",
+  "version": %q,
+  "author": "Platform team",
+  "achievements": [%s]
+}`, version, achievements)
+	require.NoError(t, os.MkdirAll(dir, 0777))
+	require.NoError(t, os.WriteFile(path.Join(dir, manifestFile), []byte(manifest), 0664))
+}
+
+const (
+	shipIt = `{"name": "Ship it", "first": {"subcommand": ["ourctl", "deploy"]}}`
+	// same name as a built-in achievement
+	gopher = `{"name": "Gopher", "first": {"command": "ourctl"}}`
+	undo   = `{"name": "Undo", "first": {"subcommand": ["ourctl", "rollback"]}}`
+)
+
+func TestPacks(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	source := t.TempDir()
+	writeManifest(t, source, "1.0.0", shipIt+","+gopher)
+
+	pack, err := InstallPack(storagePath, source)
+	require.NoError(t, err)
+	assert.Equal(t, "ourteam", pack.Name)
+	assert.Equal(t, "1.0.0", pack.Version)
+	assert.Equal(t, "Platform team", pack.Author)
+	assert.Len(t, pack.Achievements, 2)
+
+	_, err = InstallPack(storagePath, source)
+	assert.ErrorContains(t, err, "already installed")
+
+	packs, err := InstalledPacks(storagePath)
+	require.NoError(t, err)
+	require.Len(t, packs, 1)
+	assert.Equal(t, source, packs[0].Origin)
+
+	// pack achievements are namespaced, and don't collide with built-ins
+	all, err := Load(storagePath)
+	require.NoError(t, err)
+	require.Len(t, all, len(Achievements)+2)
+	assert.Equal(t, "ourteam/ship-it", all[len(Achievements)].Key())
+
+	require.NoError(t, os.WriteFile(walPath(storagePath), []byte(`{"cmd":"ourctl","subcommand":"deploy","at":"2022-11-01T12:00:00Z"}`+"\n"), 0664))
+	engine, err := NewEngine(storagePath, all)
+	require.NoError(t, err)
+	require.NoError(t, engine.Update())
+	assert.True(t, engine.Progress(all[len(Achievements)]).Awarded())
+	assert.False(t, engine.Progress(achievement(t, "Gopher")).Awarded())
+
+	// nothing to upgrade
+	installed, upgraded, err := UpgradePack(storagePath, "ourteam")
+	require.NoError(t, err)
+	assert.Same(t, installed, upgraded)
+
+	writeManifest(t, source, "1.1.0", shipIt+","+undo)
+	installed, upgraded, err = UpgradePack(storagePath, "ourteam")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", installed.Version)
+	assert.Equal(t, "1.1.0", upgraded.Version)
+
+	// progress survives the upgrade
+	all, err = Load(storagePath)
+	require.NoError(t, err)
+	engine, err = NewEngine(storagePath, all)
+	require.NoError(t, err)
+	require.NoError(t, engine.Update())
+	awarded := engine.Awarded()
+	last := awarded[len(awarded)-1]
+	assert.Equal(t, "Ship it", last.Name)
+	assert.Equal(t, "ourteam", last.Pack)
+
+	// a corrupt pack is installed again
+	require.NoError(t, os.WriteFile(path.Join(packDir(storagePath, "ourteam"), manifestFile), []byte(`{"name":`), 0664))
+	installed, upgraded, err = UpgradePack(storagePath, "ourteam")
+	require.NoError(t, err)
+	assert.Nil(t, installed)
+	assert.Equal(t, "1.1.0", upgraded.Version)
+	packs, err = InstalledPacks(storagePath)
+	require.NoError(t, err)
+	require.Len(t, packs, 1)
+
+	// names can't reach out of the packs directory
+	require.NoError(t, os.WriteFile(path.Join(string(storagePath), manifestFile), []byte(`{}`), 0664))
+	assert.ErrorContains(t, RemovePack(storagePath, ".."), "invalid pack name")
+	_, _, err = UpgradePack(storagePath, "..")
+	assert.ErrorContains(t, err, "invalid pack name")
+	assert.FileExists(t, path.Join(string(storagePath), manifestFile))
+
+	require.NoError(t, RemovePack(storagePath, "ourteam"))
+	assert.Error(t, RemovePack(storagePath, "ourteam"))
+	packs, err = InstalledPacks(storagePath)
+	require.NoError(t, err)
+	assert.Empty(t, packs)
+}
+
+func TestInstallInvalidPack(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+
+	cases := []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{"bad name", `{"name": "Our Team", "version": "1.0.0", "author": "me", "achievements": []}`, `pack.json:1: invalid name "Our Team"`},
+		{"bad version", `{"name": "ourteam", "version": "latest", "author": "me", "achievements": []}`, `pack.json:1: invalid version "latest"`},
+		{"missing author", "{\n\"name\": \"ourteam\", \"version\": \"1\", \"achievements\": []}", "pack.json:1: missing author"},
+		{"bad achievement", "{\"name\": \"ourteam\", \"version\": \"1\", \"author\": \"me\", \"achievements\": [\n" + `{"name": "x", "first": {"hour_range": [1]}}]}`, "pack.json:2: hour_range must be two hours"},
+		{"duplicate achievement", "{\"name\": \"ourteam\", \"version\": \"1\", \"author\": \"me\", \"achievements\": [\n" + shipIt + ",\n" + shipIt + "]}", `pack.json:3: duplicate achievement "ourteam/ship-it"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			source := t.TempDir()
+			require.NoError(t, os.WriteFile(path.Join(source, manifestFile), []byte(tc.manifest), 0664))
+
+			_, err := InstallPack(storagePath, source)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	packs, err := InstalledPacks(storagePath)
+	require.NoError(t, err)
+	assert.Empty(t, packs)
+}
+
+func TestInstallPackFromGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	repo := t.TempDir()
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+	git("init", "--quiet")
+	writeManifest(t, repo, "1.0.0", shipIt)
+	git("add", ".")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1")
+	writeManifest(t, repo, "2.0.0", shipIt+","+undo)
+	git("commit", "--quiet", "-am", "v2")
+
+	storagePath := state.StoragePath(t.TempDir())
+	pack, err := InstallPack(storagePath, "file://"+repo+"#v1")
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0", pack.Version)
+
+	require.NoError(t, RemovePack(storagePath, "ourteam"))
+	pack, err = InstallPack(storagePath, "file://"+repo)
+	require.NoError(t, err)
+	assert.Equal(t, "2.0.0", pack.Version)
+	assert.Len(t, pack.Achievements, 2)
+}
+
+func TestCompareVersions(t *testing.T) {
+	assert.Equal(t, 0, compareVersions("1.2.0", "1.2"))
+	assert.Equal(t, -1, compareVersions("1.2.0", "1.10.0"))
+	assert.Equal(t, 1, compareVersions("2", "1.9.9"))
+}
@@ -0,0 +1,158 @@
+package achievements
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+)
+
+const (
+	// questsPerDay is how many quests are picked each day
+	questsPerDay = 3
+
+	// questHistory is how long the progress of old quests is kept
+	questHistory = 30 * 24 * time.Hour
+
+	dayFormat = "2006-01-02"
+)
+
+// Quest is a small goal that has to be reached within a period, like a day
+type Quest struct {
+	ID          string // unique within the period, like "git-commit-3"
+	Description string
+	Period      Period
+	Start       time.Time // the first day of the period, see Calendar.Day
+	Badge       string    // awarded on completion, only for weekly challenges
+	Func        AchievementFunc
+}
+
+// key identifies the quest in the snapshot, starting with the day so that
+// old quests are easy to find
+func (q Quest) key() string {
+	return q.Start.Format(dayFormat) + "/" + string(q.Period) + "/" + q.ID
+}
+
+// questTemplate describes a kind of quest, with the targets it can have
+type questTemplate struct {
+	id          string
+	description string // formatted with the target
+	condition   ConditionFunc
+	targets     []int
+}
+
+// questTemplates are general enough that everyone can complete them
+var questTemplates = []questTemplate{
+	{"commands", "Run %d commands", anyCommand, []int{25, 50, 100}},
+	{"succeeded", "Run %d commands that succeed", succeeded, []int{20, 40}},
+	{"git-commit", "Make %d git commits", withSubCommand("git", "commit"), []int{1, 3, 5}},
+	{"git-push", "Push %d times", withSubCommand("git", "push"), []int{1, 2, 3}},
+	{"git-status", "Check git status %d times", withSubCommand("git", "status"), []int{5, 10}},
+	{"tests", "Run the tests %d times", anyTest, []int{3, 5, 10}},
+	{"builds", "Build %d times", anyBuild, []int{3, 5, 10}},
+	{"search", "Search with grep or rg %d times", or(withCommand("grep"), withCommand("rg")), []int{3, 5}},
+	{"editor", "Open an editor %d times", anyEditor, []int{3, 5, 10}},
+	{"ls", "Look around with ls %d times", withCommand("ls"), []int{10, 20}},
+	{"morning", "Run %d commands before 10:00", withHourRange(5, 9), []int{5, 10}},
+}
+
+// DailyQuests picks the quests for the day that t is in. The same quests are
+// picked every time for the day and pet, but they differ between pets.
+func DailyQuests(calendar Calendar, t time.Time, petName string) []Quest {
+	day := calendar.Day(t)
+	return pickQuests(day, Daily, calendar, petName, questsPerDay)
+}
+
+// pickQuests picks n quests for the period starting on the day
+func pickQuests(start time.Time, period Period, calendar Calendar, seed string, n int) []Quest {
+	r := questRand(start, period, seed)
+	during := inPeriod(calendar, start, period)
+	var quests []Quest
+	for _, i := range r.Perm(len(questTemplates))[:n] {
+		tmpl := questTemplates[i]
+		target := tmpl.targets[r.Intn(len(tmpl.targets))]
+		quests = append(quests, Quest{
+			ID:          fmt.Sprintf("%s-%d", tmpl.id, target),
+			Description: fmt.Sprintf(tmpl.description, target),
+			Period:      period,
+			Start:       start,
+			Func:        nth(and(during, tmpl.condition), target),
+		})
+	}
+	return quests
+}
+
+// questRand returns the same random numbers for the period and seed
+func questRand(start time.Time, period Period, seed string) *rand.Rand {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%s/%s", period, start.Format(dayFormat), seed)
+	return rand.New(rand.NewSource(int64(h.Sum64())))
+}
+
+// inPeriod is true for events during the period starting on the day
+func inPeriod(calendar Calendar, start time.Time, period Period) ConditionFunc {
+	end := period.next(start)
+	return func(event HistoryEvent) bool {
+		day := calendar.Day(event.At)
+		return !day.Before(start) && day.Before(end)
+	}
+}
+
+// TrackQuests makes the engine track the progress of the quests, it has to
+// be called before Update. Quests that are new catch up on the events in the
+// wal. The progress of quests that started more than questHistory before the
+// latest one is forgotten.
+func (e *Engine) TrackQuests(quests []Quest) error {
+	if e.snapshot.Quests == nil {
+		e.snapshot.Quests = map[string]json.RawMessage{}
+	}
+	if e.quests == nil {
+		e.quests = map[string]Evaluator{}
+	}
+
+	var latest time.Time
+	for _, q := range quests {
+		if q.Start.After(latest) {
+			latest = q.Start
+		}
+	}
+	cutoff := latest.Add(-questHistory).Format(dayFormat)
+	for key := range e.snapshot.Quests {
+		if key < cutoff {
+			delete(e.snapshot.Quests, key)
+		}
+	}
+
+	for _, q := range quests {
+		if _, ok := e.quests[q.key()]; ok {
+			continue
+		}
+		ev := q.Func()
+		if raw, ok := e.snapshot.Quests[q.key()]; ok {
+			if err := json.Unmarshal(raw, ev); err != nil {
+				return fmt.Errorf("failed to restore quest %q: %w", q.key(), err)
+			}
+		} else {
+			e.fresh = append(e.fresh, ev)
+		}
+		e.quests[q.key()] = ev
+		e.ordered = append(e.ordered, ev)
+		e.tracked = append(e.tracked, q)
+	}
+	return nil
+}
+
+// Quests returns the quests that are tracked
+func (e *Engine) Quests() []Quest {
+	return e.tracked
+}
+
+// QuestProgress returns the progress of a tracked quest, which is completed
+// once it's awarded
+func (e *Engine) QuestProgress(q Quest) Progress {
+	if ev, ok := e.quests[q.key()]; ok {
+		return ev.Progress()
+	}
+	return Progress{}
+}
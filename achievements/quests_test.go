@@ -0,0 +1,82 @@
+package achievements
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+func TestDailyQuests(t *testing.T) {
+	cal := Calendar{Location: time.UTC, Rollover: 4}
+	day := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	ids := func(quests []Quest) []string {
+		var res []string
+		for _, q := range quests {
+			res = append(res, q.ID)
+		}
+		return res
+	}
+
+	quests := DailyQuests(cal, day, "Marble")
+	require.Len(t, quests, questsPerDay)
+	assert.Equal(t, ids(quests), ids(DailyQuests(cal, day.Add(10*time.Hour), "Marble")), "the same all day")
+	assert.Equal(t, ids(quests), ids(DailyQuests(cal, day.Add(15*time.Hour), "Marble")), "until the day rolls over")
+	assert.Equal(t, time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC), quests[0].Start)
+
+	// quests differ between days and pets
+	seen := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		seen[ids(DailyQuests(cal, day.AddDate(0, 0, i), "Marble"))[0]] = true
+		seen[ids(DailyQuests(cal, day, string(rune('A'+i))))[0]] = true
+	}
+	assert.Greater(t, len(seen), 3)
+}
+
+func TestTrackQuests(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	cal := Calendar{Location: time.UTC}
+	day := time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC)
+	quest := Quest{ID: "ls-3", Period: Daily, Start: day, Func: nth(and(inPeriod(cal, day, Daily), withCommand("ls")), 3)}
+
+	ls := func(at time.Time) string {
+		return `{"cmd":"ls","at":"` + at.Format(time.RFC3339) + `"}` + "\n"
+	}
+	// the day before doesn't count
+	wal := ls(day.Add(-time.Hour)) + ls(day.Add(time.Hour)) + ls(day.Add(2*time.Hour))
+	require.NoError(t, os.WriteFile(walPath(storagePath), []byte(wal), 0664))
+
+	// events from before the quest was tracked are caught up on
+	engine := updatedEngine(t, storagePath)
+	require.NoError(t, engine.TrackQuests([]Quest{quest}))
+	require.NoError(t, engine.Update())
+	assert.Equal(t, 2, engine.QuestProgress(quest).Count)
+	assert.False(t, engine.QuestProgress(quest).Awarded())
+
+	fp, err := os.OpenFile(walPath(storagePath), os.O_APPEND|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	_, err = fp.WriteString(ls(day.Add(3*time.Hour)) + ls(day.Add(25*time.Hour)))
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	// and the progress is kept between runs
+	engine, err = NewEngine(storagePath, Achievements)
+	require.NoError(t, err)
+	require.NoError(t, engine.TrackQuests([]Quest{quest}))
+	require.NoError(t, engine.Update())
+	progress := engine.QuestProgress(quest)
+	require.True(t, progress.Awarded())
+	assert.Equal(t, day.Add(3*time.Hour), progress.AwardedAt.UTC())
+	assert.Equal(t, 3, progress.Count)
+	require.Len(t, engine.Quests(), 1)
+	assert.Equal(t, quest.key(), engine.Quests()[0].key())
+
+	// until it's too old
+	later := Quest{ID: "ls-1", Period: Daily, Start: day.AddDate(0, 0, 31), Func: nth(withCommand("ls"), 1)}
+	require.NoError(t, engine.TrackQuests([]Quest{later}))
+	assert.NotContains(t, engine.snapshot.Quests, quest.key())
+}
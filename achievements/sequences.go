@@ -0,0 +1,119 @@
+package achievements
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AnyGap lets any number of events come between two steps of a sequence
+const AnyGap = -1
+
+// Step is one step of a sequence of events
+type Step struct {
+	Condition ConditionFunc
+
+	// Within is how soon after the previous step this one has to happen, or
+	// zero for any time
+	Within time.Duration
+
+	// Gap is how many other events may come between the previous step and
+	// this one, zero for right after it or AnyGap
+	Gap int
+}
+
+// sequenceCounter counts the times the steps were matched in order, and is
+// awarded on the nth time.
+//
+// Only the latest partial match at each step is kept: a match that got to a
+// step later has had fewer events and less time pass since, so it's the
+// one most likely to be completed. That keeps the state as small as the
+// sequence, however long the history is.
+type sequenceCounter struct {
+	steps []Step
+	n     int
+	state sequenceState
+}
+
+type sequenceState struct {
+	Progress
+	Partial []partialMatch `json:"partial,omitempty"`
+}
+
+// partialMatch is a sequence that has been matched up to, but not including,
+// Step
+type partialMatch struct {
+	Step int       `json:"step"`
+	At   time.Time `json:"at"`  // when the previous step was matched
+	Gap  int       `json:"gap"` // events since the previous step
+}
+
+func (s *sequenceCounter) Add(event HistoryEvent) {
+	// later steps first, so that a match doesn't advance twice on one event
+	var next []partialMatch
+	completed := false
+	for i := len(s.state.Partial) - 1; i >= 0; i-- {
+		p := s.state.Partial[i]
+		step := s.steps[p.Step]
+		if step.Within > 0 && event.At.Sub(p.At) > step.Within {
+			continue
+		}
+		if step.Condition(event) {
+			if p.Step == len(s.steps)-1 {
+				completed = true
+			} else {
+				next = advance(next, partialMatch{Step: p.Step + 1, At: event.At})
+			}
+			continue
+		}
+		if p.Gap++; step.Gap != AnyGap && p.Gap > step.Gap {
+			continue
+		}
+		next = advance(next, p)
+	}
+	if s.steps[0].Condition(event) {
+		if len(s.steps) == 1 {
+			completed = true
+		} else {
+			next = advance(next, partialMatch{Step: 1, At: event.At})
+		}
+	}
+
+	// keep them ordered by step
+	for i, j := 0, len(next)-1; i < j; i, j = i+1, j-1 {
+		next[i], next[j] = next[j], next[i]
+	}
+	s.state.Partial = next
+
+	if completed {
+		s.state.Count++
+		if s.state.Count == s.n && !s.state.Awarded() {
+			at := event.At
+			s.state.AwardedAt = &at
+		}
+	}
+}
+
+// advance adds a partial match to matches, which are ordered from the last
+// step. As they are added in that order too, a match at the same step as the
+// last one is later, and replaces it.
+func advance(matches []partialMatch, p partialMatch) []partialMatch {
+	if n := len(matches); n > 0 && matches[n-1].Step == p.Step {
+		matches[n-1] = p
+		return matches
+	}
+	return append(matches, p)
+}
+
+func (s *sequenceCounter) Progress() Progress {
+	p := s.state.Progress
+	p.Target = s.n
+	return p
+}
+
+func (s *sequenceCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.state)
+}
+
+func (s *sequenceCounter) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &s.state)
+}
@@ -0,0 +1,95 @@
+package achievements
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequences(t *testing.T) {
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+
+	// events are a second apart, with the exit code after a colon
+	events := func(cmds ...string) []HistoryEvent {
+		var res []HistoryEvent
+		for i, c := range cmds {
+			e := HistoryEvent{Cmd: c, At: start.Add(time.Duration(i) * time.Second)}
+			if cmd, code, ok := strings.Cut(c, ":"); ok {
+				exitCode, _ := strconv.Atoi(code)
+				e.Cmd, e.ExitCode = cmd, &exitCode
+			}
+			res = append(res, e)
+		}
+		return res
+	}
+	exited := func(cmd string, failed bool) ConditionFunc {
+		return func(e HistoryEvent) bool {
+			return e.Cmd == cmd && e.ExitCode != nil && (*e.ExitCode != 0) == failed
+		}
+	}
+	failures := sequence(1, then(exited("cargo", true)), then(exited("cargo", true)), then(exited("cargo", true)), then(exited("cargo", false)))
+
+	cases := []struct {
+		name    string
+		fn      AchievementFunc
+		events  []HistoryEvent
+		matches int
+	}{
+		{"right after", sequence(1, then(withCommand("a")), then(withCommand("b"))), events("a", "b", "a", "c", "b"), 1},
+		{"gap", sequence(1, then(withCommand("a")), Step{Condition: withCommand("b"), Gap: 1}), events("a", "c", "b", "a", "c", "c", "b"), 1},
+		{"any gap", sequence(1, then(withCommand("a")), Step{Condition: withCommand("b"), Gap: AnyGap}), events("a", "c", "c", "c", "b"), 1},
+		{"within", sequence(1, then(withCommand("a")), within(withCommand("b"), 2*time.Second)), events("a", "c", "b", "a", "c", "c", "b"), 1},
+		{"latest start", sequence(1, then(withCommand("a")), within(withCommand("b"), 2*time.Second)), events("a", "c", "a", "c", "b"), 1},
+		{"overlapping", sequence(1, then(withCommand("a")), then(withCommand("a"))), events("a", "a", "a"), 2},
+		{"single step", sequence(1, then(withCommand("a"))), events("a", "b", "a"), 2},
+		{"three failures and a success", failures, events("cargo:1", "cargo:1", "cargo:1", "cargo:0"), 1},
+		{"more failures", failures, events("cargo:1", "cargo:1", "cargo:1", "cargo:1", "cargo:0"), 1},
+		{"too few failures", failures, events("cargo:1", "cargo:1", "cargo:0", "cargo:1", "cargo:0"), 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ev := tc.fn()
+			for _, e := range tc.events {
+				ev.Add(e)
+			}
+			assert.Equal(t, tc.matches, ev.Progress().Count)
+			assert.Equal(t, tc.matches > 0, ev.Progress().Awarded())
+		})
+	}
+}
+
+func TestSequenceState(t *testing.T) {
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	fn := sequence(2, then(withCommand("a")), then(withCommand("b")), then(withCommand("c")))
+
+	// the partial match is kept between runs
+	ev := fn()
+	ev.Add(HistoryEvent{Cmd: "a", At: start})
+	ev.Add(HistoryEvent{Cmd: "b", At: start.Add(time.Second)})
+	raw, err := json.Marshal(ev)
+	require.NoError(t, err)
+
+	restored := fn()
+	require.NoError(t, json.Unmarshal(raw, restored))
+	restored.Add(HistoryEvent{Cmd: "c", At: start.Add(2 * time.Second)})
+	assert.Equal(t, 1, restored.Progress().Count)
+	assert.False(t, restored.Progress().Awarded())
+
+	for i := 0; i < 3; i++ {
+		restored.Add(HistoryEvent{Cmd: string(rune('a' + i)), At: start.Add(time.Minute + time.Duration(i)*time.Second)})
+	}
+	require.True(t, restored.Progress().Awarded())
+	assert.Equal(t, start.Add(time.Minute+2*time.Second), *restored.Progress().AwardedAt)
+
+	// only the latest partial match at each step is kept
+	for i := 0; i < 1000; i++ {
+		restored.Add(HistoryEvent{Cmd: "a", At: start.Add(time.Hour)})
+	}
+	assert.LessOrEqual(t, len(restored.(*sequenceCounter).state.Partial), 2)
+}
@@ -0,0 +1,185 @@
+package achievements
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Calendar decides which day an event belongs to. Night owls can move the
+// start of the day past midnight, so that a late night still counts towards
+// the day before.
+type Calendar struct {
+	Location *time.Location // nil for the local time zone
+	Rollover int            // the hour the day starts at, 0-23
+}
+
+// NewCalendar creates a calendar for a time zone name like "Europe/Stockholm",
+// an empty name is the local time zone
+func NewCalendar(timezone string, rollover int) (Calendar, error) {
+	if rollover < 0 || rollover > 23 {
+		return Calendar{}, fmt.Errorf("invalid day rollover hour %d, must be 0-23", rollover)
+	}
+	cal := Calendar{Rollover: rollover}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return Calendar{}, fmt.Errorf("invalid timezone: %w", err)
+		}
+		cal.Location = loc
+	}
+	return cal, nil
+}
+
+// Day returns the date that t belongs to, as midnight UTC so that days can be
+// compared and added without daylight saving time getting in the way
+func (c Calendar) Day(t time.Time) time.Time {
+	loc := c.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	y, m, d := t.In(loc).Add(-time.Duration(c.Rollover) * time.Hour).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Hour returns the hour of the day that t is in, counted from when the day
+// starts. With the day starting at 4, 03:00 is hour 23 of the day before.
+func (c Calendar) Hour(t time.Time) int {
+	loc := c.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	return t.In(loc).Add(-time.Duration(c.Rollover) * time.Hour).Hour()
+}
+
+// Period is how often an event has to happen to keep a streak going
+type Period string
+
+const (
+	Daily    Period = "daily"
+	Weekdays Period = "weekdays" // daily, but weekends neither count nor break the streak
+	Weekly   Period = "weekly"   // weeks start on Mondays
+)
+
+func (p Period) valid() bool {
+	return p == Daily || p == Weekdays || p == Weekly
+}
+
+// start returns the first day of the period that day is in
+func (p Period) start(day time.Time) time.Time {
+	if p == Weekly {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+// next returns the period after the one starting on start
+func (p Period) next(start time.Time) time.Time {
+	switch p {
+	case Weekly:
+		return start.AddDate(0, 0, 7)
+	case Weekdays:
+		next := start.AddDate(0, 0, 1)
+		for isWeekend(next) {
+			next = next.AddDate(0, 0, 1)
+		}
+		return next
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+func isWeekend(day time.Time) bool {
+	return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+}
+
+// Streak is how many periods in a row an achievement's condition was met
+type Streak struct {
+	Period  Period
+	Current int // zero if the streak has been broken
+	Longest int
+}
+
+// streakCounter keeps track of a streak, and is awarded once it's n periods
+// long. The progress counts the longest streak. Events have to be added in
+// the order they happened, as only the last period is kept: the engine
+// rebuilds its evaluators when history is imported from before the events
+// they have seen.
+type streakCounter struct {
+	condition ConditionFunc
+	period    Period
+	n         int
+	calendar  Calendar
+	state     streakState
+}
+
+type streakState struct {
+	Progress
+	Current int       `json:"current"`
+	Last    time.Time `json:"last"` // start of the last period the condition was met in
+}
+
+func (s *streakCounter) Add(event HistoryEvent) {
+	if !s.condition(event) {
+		return
+	}
+	day := s.calendar.Day(event.At)
+	if s.period == Weekdays && isWeekend(day) {
+		return
+	}
+
+	start := s.period.start(day)
+	switch {
+	case !start.After(s.state.Last):
+		// already counted, events out of order are the engine's to sort
+		return
+	case s.state.Current > 0 && start.Equal(s.period.next(s.state.Last)):
+		s.state.Current++
+	default:
+		s.state.Current = 1
+	}
+	s.state.Last = start
+
+	if s.state.Current > s.state.Count {
+		s.state.Count = s.state.Current
+	}
+	if s.state.Count >= s.n && !s.state.Awarded() {
+		at := event.At
+		s.state.AwardedAt = &at
+	}
+}
+
+func (s *streakCounter) Progress() Progress {
+	p := s.state.Progress
+	p.Target = s.n
+	return p
+}
+
+// Streak returns the streak as of now. A streak isn't broken until the
+// period after the last one has passed without the condition being met.
+func (s *streakCounter) Streak(now time.Time) Streak {
+	streak := Streak{Period: s.period, Longest: s.state.Count}
+
+	today := s.period.start(s.calendar.Day(now))
+	if s.period == Weekdays {
+		for isWeekend(today) {
+			today = today.AddDate(0, 0, -1)
+		}
+	}
+	if !s.state.Last.IsZero() && !today.After(s.period.next(s.state.Last)) {
+		streak.Current = s.state.Current
+	}
+	return streak
+}
+
+func (s *streakCounter) setCalendar(calendar Calendar) {
+	s.calendar = calendar
+}
+
+func (s *streakCounter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.state)
+}
+
+func (s *streakCounter) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &s.state)
+}
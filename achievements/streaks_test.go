@@ -0,0 +1,96 @@
+package achievements
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendar(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		timezone string
+		rollover int
+		at       time.Time
+		day      string
+	}{
+		{"midnight", "UTC", 0, time.Date(2022, 11, 1, 0, 0, 0, 0, time.UTC), "2022-11-01"},
+		{"before rollover", "UTC", 4, time.Date(2022, 11, 1, 3, 59, 0, 0, time.UTC), "2022-10-31"},
+		{"after rollover", "UTC", 4, time.Date(2022, 11, 1, 4, 0, 0, 0, time.UTC), "2022-11-01"},
+		{"timezone", "Europe/Stockholm", 0, time.Date(2022, 11, 1, 23, 30, 0, 0, time.UTC), "2022-11-02"},
+		{"event in another timezone", "UTC", 0, time.Date(2022, 11, 2, 0, 30, 0, 0, stockholm), "2022-11-01"},
+		{"daylight saving time", "Europe/Stockholm", 2, time.Date(2022, 10, 30, 2, 30, 0, 0, stockholm), "2022-10-30"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cal, err := NewCalendar(tc.timezone, tc.rollover)
+			require.NoError(t, err)
+			assert.Equal(t, tc.day, cal.Day(tc.at).Format("2006-01-02"))
+		})
+	}
+
+	_, err = NewCalendar("Mars/Olympus_Mons", 0)
+	assert.Error(t, err)
+	_, err = NewCalendar("", 24)
+	assert.Error(t, err)
+}
+
+func TestStreaks(t *testing.T) {
+	cal := Calendar{Location: time.UTC, Rollover: 4}
+	// a Monday
+	monday := time.Date(2022, 10, 31, 12, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time {
+		return monday.AddDate(0, 0, n)
+	}
+
+	cases := []struct {
+		name    string
+		period  Period
+		days    []time.Time
+		now     time.Time
+		current int
+		longest int
+	}{
+		{"daily", Daily, []time.Time{day(0), day(1), day(2)}, day(2), 3, 3},
+		{"same day twice", Daily, []time.Time{day(0), day(0), day(1)}, day(1), 2, 2},
+		{"not broken until the day has passed", Daily, []time.Time{day(0), day(1)}, day(2), 2, 2},
+		{"broken", Daily, []time.Time{day(0), day(1)}, day(3), 0, 2},
+		{"restarted", Daily, []time.Time{day(0), day(1), day(2), day(4)}, day(4), 1, 3},
+		{"night owl", Daily, []time.Time{day(0), day(1).Add(15 * time.Hour), day(2)}, day(2), 3, 3},
+		{"weekdays skip weekends", Weekdays, []time.Time{day(3), day(4), day(7)}, day(7), 3, 3},
+		{"weekends don't count", Weekdays, []time.Time{day(4), day(5), day(6)}, day(6), 1, 1},
+		{"not broken over the weekend", Weekdays, []time.Time{day(3), day(4)}, day(7), 2, 2},
+		{"weekly", Weekly, []time.Time{day(0), day(13), day(14)}, day(20), 3, 3},
+		{"weekly broken", Weekly, []time.Time{day(0), day(6), day(14)}, day(14), 1, 1},
+		{"out of order, see TestImportAfterFirstLaunch", Daily, []time.Time{day(2), day(0), day(1)}, day(2), 1, 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ev := streak(withCommand("git"), tc.period, 100)().(*streakCounter)
+			ev.setCalendar(cal)
+			for _, at := range tc.days {
+				ev.Add(HistoryEvent{Cmd: "git", At: at})
+			}
+			assert.Equal(t, Streak{Period: tc.period, Current: tc.current, Longest: tc.longest}, ev.Streak(tc.now))
+		})
+	}
+}
+
+func TestStreakAwarded(t *testing.T) {
+	ev := streak(withCommand("git"), Daily, 3)()
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 5; i++ {
+		ev.Add(HistoryEvent{Cmd: "git", At: start.AddDate(0, 0, i)})
+		ev.Add(HistoryEvent{Cmd: "ls", At: start.AddDate(0, 0, i)})
+	}
+	require.True(t, ev.Progress().Awarded())
+	assert.Equal(t, start.AddDate(0, 0, 2), *ev.Progress().AwardedAt)
+	assert.Equal(t, 5, ev.Progress().Count)
+}
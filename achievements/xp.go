@@ -0,0 +1,98 @@
+package achievements
+
+// Rarity is how hard an achievement is to get, which decides how much XP it
+// gives
+type Rarity string
+
+const (
+	Common    Rarity = "common"
+	Rare      Rarity = "rare"
+	Epic      Rarity = "epic"
+	Legendary Rarity = "legendary"
+)
+
+// rarities are ordered from the most common, with the target an achievement
+// has to reach to be of the rarity when it isn't set explicitly
+var rarities = []struct {
+	rarity Rarity
+	target int
+	xp     int
+}{
+	{Common, 1, 10},
+	{Rare, 50, 50},
+	{Epic, 250, 150},
+	{Legendary, 1000, 500},
+}
+
+func (r Rarity) valid() bool {
+	for _, t := range rarities {
+		if t.rarity == r {
+			return true
+		}
+	}
+	return false
+}
+
+// XP is how much XP achievements of the rarity give
+func (r Rarity) XP() int {
+	for _, t := range rarities {
+		if t.rarity == r {
+			return t.xp
+		}
+	}
+	return 0
+}
+
+// rarityOf returns the rarity of achievements that are awarded at the target
+func rarityOf(target int) Rarity {
+	rarity := Common
+	for _, t := range rarities {
+		if target >= t.target {
+			rarity = t.rarity
+		}
+	}
+	return rarity
+}
+
+// Tier returns the rarity of the achievement, which is derived from how many
+// times it has to be done unless it's set
+func (a Achievement) Tier() Rarity {
+	if a.Rarity != "" {
+		return a.Rarity
+	}
+	return rarityOf(a.Func().Progress().Target)
+}
+
+// XP is how much XP the achievement gives
+func (a Achievement) XP() int {
+	return a.Tier().XP()
+}
+
+// levelXP is the XP of the first level above 1, after which each level takes
+// more XP than the one before
+const levelXP = 50
+
+// Level returns the level reached with the XP. Reaching level n takes
+// levelXP * (n-1)^2 XP, so 50 for level 2, 200 for level 3 and 450 for
+// level 4.
+func Level(xp int) int {
+	level := 1
+	for LevelXP(level+1) <= xp {
+		level++
+	}
+	return level
+}
+
+// LevelXP returns the XP it takes to reach the level
+func LevelXP(level int) int {
+	return levelXP * (level - 1) * (level - 1)
+}
+
+// XP is the XP of all awarded achievements, and the bonus XP for badges
+func (e *Engine) XP() int {
+	xp := len(e.Badges()) * challengeXP
+	for _, a := range e.Awarded() {
+		xp += a.XP()
+	}
+	return xp
+}
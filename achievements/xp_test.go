@@ -0,0 +1,67 @@
+package achievements
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+func TestTier(t *testing.T) {
+	cases := []struct {
+		name   string
+		rarity Rarity
+		xp     int
+	}{
+		{"Gopher", Common, 10},
+		{"Developer", Rare, 50},
+		{"Coder", Epic, 150},
+		{"10xer", Legendary, 500},
+		{"Name your pet", Common, 10},
+		{"No days off", Epic, 150},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := achievement(t, tc.name)
+			assert.Equal(t, tc.rarity, a.Tier())
+			assert.Equal(t, tc.xp, a.XP())
+		})
+	}
+}
+
+func TestLevel(t *testing.T) {
+	cases := []struct {
+		xp    int
+		level int
+	}{
+		{0, 1},
+		{49, 1},
+		{50, 2},
+		{199, 2},
+		{200, 3},
+		{450, 4},
+		{5000, 11},
+	}
+
+	for _, tc := range cases {
+		assert.Equal(t, tc.level, Level(tc.xp), "%d XP", tc.xp)
+	}
+
+	// each level takes more XP than the one before
+	for level := 2; level < 100; level++ {
+		assert.Greater(t, LevelXP(level+1)-LevelXP(level), LevelXP(level)-LevelXP(level-1))
+		assert.Equal(t, level, Level(LevelXP(level)))
+	}
+}
+
+func TestEngineXP(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	require.NoError(t, os.WriteFile(walPath(storagePath), []byte(`{"cmd":"go","at":"2022-11-01T20:00:00Z"}`+"\n"), 0664))
+
+	engine := updatedEngine(t, storagePath)
+	// named the pet, and used go
+	assert.Equal(t, 20, engine.XP())
+}
@@ -30,6 +30,8 @@ func (m *helpModel) View() string {
 	var commands []string = []string{
 		listHeader("Commands"),
 		"a: show achievements",
+		"d: daily quests",
+		"w: weekly challenges",
 		"r: rename your pet",
 		"q / esc / enter / cmd+c: quit",
 		"",
@@ -0,0 +1,209 @@
+package ingest
+
+import (
+	"strings"
+
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+type command = state.Command
+
+var (
+	one = command{Subcommands: 1}
+	two = command{Subcommands: 2}
+
+	dockerValueFlags  = []string{"-H", "--host", "-c", "--context", "--config", "-l", "--log-level"}
+	composeValueFlags = []string{"-f", "--file", "-p", "--project-name", "--profile", "--project-directory", "--env-file", "--ansi", "--parallel"}
+	dockerNested      = map[string]command{
+		"compose": {Subcommands: 1, ValueFlags: composeValueFlags},
+		"buildx":  one, "builder": one, "config": one, "container": one,
+		"context": one, "image": one, "manifest": one, "network": one,
+		"node": one, "plugin": one, "secret": one, "service": one,
+		"stack": one, "swarm": one, "system": one, "trust": one,
+		"volume": one,
+	}
+)
+
+// defaultCommands is what is tracked for well known programs, the users
+// configuration is merged on top of it
+var defaultCommands = map[string]command{
+	// VCS
+	"git": {
+		Subcommands: 1,
+		Flags:       true,
+		ValueFlags:  []string{"-C", "-c", "--git-dir", "--work-tree", "--namespace", "--exec-path", "--config-env"},
+		Nested: map[string]command{
+			"bisect": one, "lfs": one, "notes": one, "remote": one,
+			"stash": one, "submodule": one, "worktree": one, "flow": two,
+		},
+	},
+	"gh": {
+		Subcommands: 1,
+		ValueFlags:  []string{"-R", "--repo"},
+		Nested: map[string]command{
+			"auth": one, "codespace": one, "extension": one, "gist": one,
+			"issue": one, "label": one, "pr": one, "release": one,
+			"repo": one, "run": one, "secret": one, "workflow": one,
+		},
+	},
+	"hg":  one,
+	"svn": one,
+
+	// JS fanboys
+	"npm":  {Subcommands: 1, Nested: map[string]command{"run": one, "run-script": one}},
+	"yarn": one,
+	"pnpm": one,
+	"bun":  one,
+	"deno": one,
+	"npx":  one,
+
+	// Python
+	"pip":    one,
+	"pip3":   one,
+	"poetry": one,
+	"pipenv": one,
+	"uv":     {Subcommands: 1, Nested: map[string]command{"pip": one, "tool": one, "python": one}},
+	"conda":  one,
+
+	// Go
+	"go": {
+		Subcommands: 1,
+		Flags:       true,
+		Nested:      map[string]command{"mod": one, "work": one, "tool": one},
+	},
+
+	// Rust
+	"cargo":  {Subcommands: 1, Flags: true},
+	"rustup": {Subcommands: 1, Nested: map[string]command{"component": one, "target": one, "toolchain": one}},
+
+	// JVM
+	"gradle":  one,
+	"gradlew": one,
+	"mvn":     one,
+	"sbt":     one,
+
+	// Other languages
+	"bundle":   one,
+	"gem":      one,
+	"rails":    one,
+	"mix":      one,
+	"dotnet":   {Subcommands: 1, Nested: map[string]command{"tool": one, "new": one}},
+	"composer": one,
+	"swift":    {Subcommands: 1, Nested: map[string]command{"package": one}},
+	"flutter":  one,
+
+	// Build tools
+	"make":  {Subcommands: 1, ValueFlags: []string{"-C", "-f", "-j", "-I", "-o", "-W"}},
+	"just":  one,
+	"bazel": {Subcommands: 1, Flags: true},
+	"cmake": {Flags: true},
+
+	// Containers
+	"docker": {
+		Subcommands: 1,
+		Flags:       true,
+		ValueFlags:  dockerValueFlags,
+		Nested:      dockerNested,
+	},
+	"podman": {
+		Subcommands: 1,
+		Flags:       true,
+		ValueFlags:  dockerValueFlags,
+		Nested:      dockerNested,
+	},
+	"docker-compose": {Subcommands: 1, ValueFlags: composeValueFlags},
+	"kubectl": {
+		Subcommands: 1,
+		Flags:       true,
+		ValueFlags:  []string{"-n", "--namespace", "--context", "--cluster", "--kubeconfig", "--user", "-s", "--server", "--as", "--token"},
+		Nested: map[string]command{
+			"auth": one, "certificate": one, "config": one, "create": one,
+			"rollout": one, "set": one, "top": one,
+		},
+	},
+	"helm": {
+		Subcommands: 1,
+		ValueFlags:  []string{"-n", "--namespace", "--kube-context", "--kubeconfig"},
+		Nested:      map[string]command{"dependency": one, "plugin": one, "registry": one, "repo": one},
+	},
+	"minikube": one,
+	"kind":     {Subcommands: 1, Nested: map[string]command{"create": one, "delete": one, "get": one, "load": one}},
+
+	// Cloud and infrastructure
+	"aws":            {Subcommands: 2, ValueFlags: []string{"--profile", "--region", "--output", "--endpoint-url"}},
+	"gcloud":         {Subcommands: 3, ValueFlags: []string{"--project", "--account", "--configuration"}},
+	"az":             {Subcommands: 2, ValueFlags: []string{"--subscription", "-o", "--output"}},
+	"terraform":      {Subcommands: 1, Flags: true, Nested: map[string]command{"state": one, "workspace": one}},
+	"tofu":           {Subcommands: 1, Flags: true, Nested: map[string]command{"state": one, "workspace": one}},
+	"pulumi":         {Subcommands: 1, Nested: map[string]command{"stack": one, "config": one}},
+	"ansible-galaxy": {Subcommands: 2},
+	"vagrant":        one,
+	"systemctl":      {Subcommands: 1, ValueFlags: []string{"-H", "--host", "-M", "--machine"}},
+	"launchctl":      one,
+
+	// Package managers
+	"brew":    {Subcommands: 1, Nested: map[string]command{"services": one}},
+	"apt":     one,
+	"apt-get": one,
+	"dnf":     one,
+	"yum":     one,
+	"snap":    one,
+	"nix":     one,
+	"port":    one,
+
+	// Misc
+	"xcode-select": {Flags: true},
+	"rm":           {Flags: true},
+	"tmux":         {Subcommands: 1, ValueFlags: []string{"-L", "-S", "-f"}},
+	"heroku":       one,
+	"fly":          one,
+	"flyctl":       one,
+	"vercel":       one,
+	"firebase":     one,
+	"stripe":       one,
+}
+
+// mergeCommands returns the default commands with the users commands on top
+func mergeCommands(user map[string]command) map[string]command {
+	if len(user) == 0 {
+		return defaultCommands
+	}
+	merged := make(map[string]command, len(defaultCommands)+len(user))
+	for name, c := range defaultCommands {
+		merged[name] = c
+	}
+	for name, c := range user {
+		merged[name] = c
+	}
+	return merged
+}
+
+// findSubcommand returns the subcommands of args, separated by spaces, as
+// described by the command.
+func findSubcommand(c command, args []string) string {
+	var subs []string
+	levels := c.Subcommands
+
+	for i := 0; i < len(args) && levels > 0; i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			if contains(c.ValueFlags, arg) {
+				i++
+			}
+			continue
+		}
+
+		subs = append(subs, arg)
+		levels--
+
+		if nested, ok := c.Nested[arg]; ok {
+			c = nested
+			levels = nested.Subcommands
+		}
+	}
+
+	return strings.Join(subs, " ")
+}
@@ -0,0 +1,424 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sturdy-dev/marblezero/achievements"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+// HistoryFormat is a shell history format that can be imported
+type HistoryFormat string
+
+const (
+	ZshHistory   HistoryFormat = "zsh"
+	BashHistory  HistoryFormat = "bash"
+	FishHistory  HistoryFormat = "fish"
+	AtuinHistory HistoryFormat = "atuin"
+)
+
+// historyEntry is a single command from a history file. The outcome is only
+// known for some formats.
+type historyEntry struct {
+	cmd      string
+	at       time.Time
+	duration time.Duration
+	exitCode *int
+	cwd      string
+}
+
+type ImportResult struct {
+	Imported     int // lines that were imported
+	Duplicates   int // lines that were already in the wal
+	NoTimestamps int // lines that could not be imported, as they have no timestamp
+	Compacted    int // lines that could not be imported, as they are older than the compacted history
+}
+
+// DefaultHistoryPath returns where the shell keeps its history by default
+func DefaultHistoryPath(format HistoryFormat) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find home dir: %w", err)
+	}
+
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		dataDir = path.Join(homeDir, ".local", "share")
+	}
+
+	switch format {
+	case ZshHistory:
+		if histFile := os.Getenv("HISTFILE"); histFile != "" && strings.Contains(histFile, "zsh") {
+			return histFile, nil
+		}
+		return path.Join(homeDir, ".zsh_history"), nil
+	case BashHistory:
+		return path.Join(homeDir, ".bash_history"), nil
+	case FishHistory:
+		return path.Join(dataDir, "fish", "fish_history"), nil
+	case AtuinHistory:
+		return path.Join(dataDir, "atuin", "history.db"), nil
+	default:
+		return "", fmt.Errorf("unknown history format: %s", format)
+	}
+}
+
+// History imports an existing shell history file into the wal. Lines that
+// already are in the wal are skipped, so it's safe to import the same file
+// several times.
+func History(storagePath state.StoragePath, config *state.Config, format HistoryFormat, historyPath string) (ImportResult, error) {
+	var result ImportResult
+
+	p, err := newParser(config)
+	if err != nil {
+		return result, err
+	}
+
+	var entries []historyEntry
+	switch format {
+	case ZshHistory, BashHistory, FishHistory:
+		fp, err := os.Open(historyPath)
+		if err != nil {
+			return result, fmt.Errorf("failed to open history: %w", err)
+		}
+		defer fp.Close()
+
+		switch format {
+		case ZshHistory:
+			entries, err = readZshHistory(fp)
+		case BashHistory:
+			entries, err = readBashHistory(fp)
+		case FishHistory:
+			entries, err = readFishHistory(fp)
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read history: %w", err)
+		}
+	case AtuinHistory:
+		entries, err = readAtuinHistory(historyPath)
+		if err != nil {
+			return result, fmt.Errorf("failed to read atuin database: %w", err)
+		}
+	default:
+		return result, fmt.Errorf("unknown history format: %s", format)
+	}
+
+	// Compacted events can't be deduplicated, so nothing older than the
+	// snapshot can be imported
+	snapshot, err := achievements.LoadSnapshot(storagePath)
+	if err != nil {
+		return result, err
+	}
+
+	existing, err := achievements.ParseHistory(storagePath)
+	var corrupt *achievements.CorruptError
+	if err != nil && !errors.As(err, &corrupt) {
+		return result, err
+	}
+	// Only lines in the wal are duplicates, the history itself can have the
+	// same line several times in a second
+	seen := make(map[lineKey]int, len(existing))
+	for _, line := range achievements.Lines(existing) {
+		seen[newLineKey(line, 0)]++
+	}
+
+	var events []achievements.HistoryEvent
+	for _, entry := range entries {
+		if entry.at.IsZero() {
+			result.NoTimestamps++
+			continue
+		}
+		if entry.at.Before(snapshot.Until) {
+			result.Compacted++
+			continue
+		}
+
+		lineEvents := p.parse(entry.cmd, "", entry.at)
+		if len(lineEvents) == 0 {
+			continue
+		}
+
+		if isDuplicate(seen, lineEvents) {
+			result.Duplicates++
+			continue
+		}
+
+		setOutcome(lineEvents, entry.exitCode, entry.duration, entry.cwd)
+		for i := range lineEvents {
+			lineEvents[i].Source = string(format)
+		}
+
+		events = append(events, lineEvents...)
+		result.Imported++
+	}
+
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].At.Before(events[b].At)
+	})
+
+	if err := write(storagePath, events); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// lineKey identifies a line in the history by the events that were recorded
+// for it, as the line itself is not kept. Histories only keep timestamps with
+// second precision.
+type lineKey struct {
+	unix   int64
+	events string
+}
+
+func newLineKey(line []achievements.HistoryEvent, offset int64) lineKey {
+	var events strings.Builder
+	for _, e := range line {
+		fmt.Fprintf(&events, "%s %s %q %q %q %q\n", e.Operator, e.Cmd, e.SubCommand, e.Flags, e.FileExtensions, e.Wrappers)
+	}
+	return lineKey{unix: line[0].At.Unix() + offset, events: events.String()}
+}
+
+// isDuplicate reports if the line is in the wal already, each line in the wal
+// is only a duplicate once. Lines recorded by the shell integration may be
+// timestamped slightly differently than in the history file, so the
+// neighbouring seconds are checked as well.
+func isDuplicate(seen map[lineKey]int, line []achievements.HistoryEvent) bool {
+	for _, offset := range []int64{0, -1, 1} {
+		key := newLineKey(line, offset)
+		if seen[key] > 0 {
+			seen[key]--
+			return true
+		}
+	}
+	return false
+}
+
+// readZshHistory reads zsh history files. Only lines in the extended format,
+// ": <start>:<elapsed>;<command>", have timestamps.
+func readZshHistory(r io.Reader) ([]historyEntry, error) {
+	var entries []historyEntry
+
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		line := unmetafy(scanner.Bytes())
+
+		// multi-line commands end each line but the last with a backslash
+		for strings.HasSuffix(line, "\\") && scanner.Scan() {
+			line = line[:len(line)-1] + "\n" + unmetafy(scanner.Bytes())
+		}
+
+		var entry historyEntry
+		if strings.HasPrefix(line, ": ") {
+			if meta, cmd, ok := strings.Cut(line[2:], ";"); ok {
+				start, elapsed, _ := strings.Cut(meta, ":")
+				if ts, err := strconv.ParseInt(start, 10, 64); err == nil {
+					entry.at = time.Unix(ts, 0)
+					if d, err := strconv.ParseInt(elapsed, 10, 64); err == nil {
+						entry.duration = time.Duration(d) * time.Second
+					}
+					line = cmd
+				}
+			}
+		}
+		entry.cmd = line
+
+		if strings.TrimSpace(entry.cmd) != "" {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// unmetafy decodes zsh's history encoding, where some bytes are escaped with
+// the Meta byte 0x83.
+func unmetafy(b []byte) string {
+	if bytes.IndexByte(b, 0x83) < 0 {
+		return string(b)
+	}
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		if b[i] == 0x83 && i+1 < len(b) {
+			i++
+			out = append(out, b[i]^32)
+			continue
+		}
+		out = append(out, b[i])
+	}
+	return string(out)
+}
+
+// readBashHistory reads bash history files. Commands only have timestamps
+// when HISTTIMEFORMAT was set, in which case they are preceded by a
+// "#<timestamp>" line.
+func readBashHistory(r io.Reader) ([]historyEntry, error) {
+	var entries []historyEntry
+	var current *historyEntry
+
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, "#") {
+			if ts, err := strconv.ParseInt(line[1:], 10, 64); err == nil {
+				entries = append(entries, historyEntry{at: time.Unix(ts, 0)})
+				current = &entries[len(entries)-1]
+				continue
+			}
+		}
+
+		switch {
+		case current != nil && current.cmd == "":
+			current.cmd = line
+		case current != nil:
+			// with lithist, multi-line commands are kept on several lines
+			current.cmd += "\n" + line
+		default:
+			entries = append(entries, historyEntry{cmd: line})
+		}
+	}
+
+	filtered := entries[:0]
+	for _, e := range entries {
+		if strings.TrimSpace(e.cmd) != "" {
+			filtered = append(filtered, e)
+		}
+	}
+
+	return filtered, scanner.Err()
+}
+
+// readFishHistory reads fish's history file, which is a subset of YAML:
+//
+//	# ~/.local/share/fish/fish_history
+//	- cmd: git status
+//	  when: 1668000000
+//	  paths:
+//	    - foo.go
+func readFishHistory(r io.Reader) ([]historyEntry, error) {
+	var entries []historyEntry
+
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "- cmd: "):
+			entries = append(entries, historyEntry{cmd: unescapeFish(strings.TrimPrefix(line, "- cmd: "))})
+		case strings.HasPrefix(line, "  when: ") && len(entries) > 0:
+			if ts, err := strconv.ParseInt(strings.TrimPrefix(line, "  when: "), 10, 64); err == nil {
+				entries[len(entries)-1].at = time.Unix(ts, 0)
+			}
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+func unescapeFish(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readAtuinHistory reads atuin's SQLite database, using the sqlite3 command
+// line tool.
+func readAtuinHistory(dbPath string) ([]historyEntry, error) {
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+
+	sqlite, err := exec.LookPath("sqlite3")
+	if err != nil {
+		return nil, errors.New("sqlite3 is required to import atuin history")
+	}
+
+	// text is hex encoded, to not have to deal with separators and newlines
+	const query = `SELECT timestamp, duration, exit, hex(cwd), hex(command) FROM history WHERE deleted_at IS NULL ORDER BY timestamp`
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(sqlite, "-readonly", "-batch", "-noheader", "-separator", "|", dbPath, query)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3 failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseAtuinRows(bytes.NewReader(out))
+}
+
+func parseAtuinRows(r io.Reader) ([]historyEntry, error) {
+	var entries []historyEntry
+
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "|")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected row: %q", scanner.Text())
+		}
+
+		ts, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %w", err)
+		}
+		cwd, err := hex.DecodeString(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cwd: %w", err)
+		}
+		command, err := hex.DecodeString(fields[4])
+		if err != nil {
+			return nil, fmt.Errorf("invalid command: %w", err)
+		}
+
+		entry := historyEntry{
+			cmd: string(command),
+			at:  time.Unix(0, ts),
+			cwd: string(cwd),
+		}
+
+		// atuin uses -1 for unknown durations and exit codes
+		if d, err := strconv.ParseInt(fields[1], 10, 64); err == nil && d >= 0 {
+			entry.duration = time.Duration(d)
+		}
+		if code, err := strconv.Atoi(fields[2]); err == nil && code >= 0 {
+			entry.exitCode = &code
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return scanner
+}
@@ -0,0 +1,157 @@
+package ingest
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/achievements"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+func TestReadZshHistory(t *testing.T) {
+	history := ": 1668000000:0;git status\n" +
+		": 1668000010:42;go test ./...\n" +
+		": 1668000100:1;echo one \\\n" +
+		"two\n" +
+		"ls -la\n" +
+		": 1668000200:0;echo caf\x83\xe3\n"
+
+	entries, err := readZshHistory(strings.NewReader(history))
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+
+	assert.Equal(t, historyEntry{cmd: "git status", at: time.Unix(1668000000, 0)}, entries[0])
+	assert.Equal(t, historyEntry{cmd: "go test ./...", at: time.Unix(1668000010, 0), duration: 42 * time.Second}, entries[1])
+	assert.Equal(t, "echo one \ntwo", entries[2].cmd)
+	assert.Equal(t, historyEntry{cmd: "ls -la"}, entries[3])
+	assert.Equal(t, "echo caf\xc3", entries[4].cmd)
+}
+
+func TestReadBashHistory(t *testing.T) {
+	history := "ls\n" +
+		"#1668000000\n" +
+		"git status\n" +
+		"#1668000010\n" +
+		"for f in *; do\n" +
+		"  echo $f\n" +
+		"done\n" +
+		"#1668000020\n" +
+		"# a comment\n"
+
+	entries, err := readBashHistory(strings.NewReader(history))
+	require.NoError(t, err)
+	require.Len(t, entries, 4)
+
+	assert.Equal(t, historyEntry{cmd: "ls"}, entries[0])
+	assert.Equal(t, historyEntry{cmd: "git status", at: time.Unix(1668000000, 0)}, entries[1])
+	assert.Equal(t, "for f in *; do\n  echo $f\ndone", entries[2].cmd)
+	assert.Equal(t, historyEntry{cmd: "# a comment", at: time.Unix(1668000020, 0)}, entries[3])
+}
+
+func TestReadFishHistory(t *testing.T) {
+	history := "- cmd: git status\n" +
+		"  when: 1668000000\n" +
+		"- cmd: echo a\\nb \\\\ c\n" +
+		"  when: 1668000010\n" +
+		"  paths:\n" +
+		"    - foo.go\n"
+
+	entries, err := readFishHistory(strings.NewReader(history))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, historyEntry{cmd: "git status", at: time.Unix(1668000000, 0)}, entries[0])
+	assert.Equal(t, historyEntry{cmd: "echo a\nb \\ c", at: time.Unix(1668000010, 0)}, entries[1])
+}
+
+func TestReadAtuinHistory(t *testing.T) {
+	if _, err := exec.LookPath("sqlite3"); err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+
+	db := path.Join(t.TempDir(), "history.db")
+	schema := `CREATE TABLE history (id text, timestamp integer, duration integer, exit integer, command text, cwd text, session text, hostname text, deleted_at integer);
+INSERT INTO history VALUES ('a', 1668000000000000000, 2500000000, 1, 'go test ./... | grep "FAIL|ok"', '/src/marble zero', 's', 'h', NULL);
+INSERT INTO history VALUES ('b', 1668000010000000000, -1, -1, 'git status', '/src', 's', 'h', NULL);
+INSERT INTO history VALUES ('c', 1668000020000000000, 0, 0, 'deleted', '/src', 's', 'h', 1668000030000000000);`
+	out, err := exec.Command("sqlite3", db, schema).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	entries, err := readAtuinHistory(db)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	one := 1
+	assert.Equal(t, historyEntry{
+		cmd:      `go test ./... | grep "FAIL|ok"`,
+		at:       time.Unix(1668000000, 0),
+		duration: 2500 * time.Millisecond,
+		exitCode: &one,
+		cwd:      "/src/marble zero",
+	}, entries[0])
+	assert.Equal(t, historyEntry{cmd: "git status", at: time.Unix(1668000010, 0), cwd: "/src"}, entries[1])
+}
+
+func TestImportHistory(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+
+	// recorded by the shell integration, slightly after the history timestamp
+	require.NoError(t, write(storagePath, parse("git status", time.Unix(1668000000, 700000000))))
+
+	historyPath := path.Join(t.TempDir(), ".zsh_history")
+	history := ": 1668000000:0;git status\n" +
+		": 1668000010:0;git add . && git commit -m wip\n" +
+		": 1668000020:0; echo hidden\n" +
+		"ls\n"
+	require.NoError(t, os.WriteFile(historyPath, []byte(history), 0600))
+
+	result, err := History(storagePath, nil, ZshHistory, historyPath)
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Imported: 1, Duplicates: 1, NoTimestamps: 1}, result)
+
+	// importing again is a no-op
+	result, err = History(storagePath, nil, ZshHistory, historyPath)
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Imported: 0, Duplicates: 2, NoTimestamps: 1}, result)
+
+	events, err := achievements.ParseHistory(storagePath)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "", events[0].Source)
+	assert.Equal(t, "commit", events[2].SubCommand)
+	assert.Equal(t, "zsh", events[2].Source)
+	assert.Equal(t, time.Unix(1668000010, 0), events[2].At.Local())
+}
+
+func TestImportHistoryRepeatedLines(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+
+	// already recorded by the shell integration
+	require.NoError(t, write(storagePath, parse("make", time.Unix(1668000000, 300000000))))
+
+	historyPath := path.Join(t.TempDir(), ".zsh_history")
+	history := ": 1668000000:0;make\n" +
+		": 1668000000:0;make\n" +
+		": 1668000000:0;make\n"
+	require.NoError(t, os.WriteFile(historyPath, []byte(history), 0600))
+
+	// the same line run several times in a second is only a duplicate of
+	// what's in the wal
+	result, err := History(storagePath, nil, ZshHistory, historyPath)
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Imported: 2, Duplicates: 1}, result)
+
+	result, err = History(storagePath, nil, ZshHistory, historyPath)
+	require.NoError(t, err)
+	assert.Equal(t, ImportResult{Duplicates: 3}, result)
+
+	events, err := achievements.ParseHistory(storagePath)
+	require.NoError(t, err)
+	assert.Len(t, events, 3)
+}
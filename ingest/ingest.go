@@ -1,92 +1,427 @@
 package ingest
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sturdy-dev/marblezero/achievements"
 	"github.com/sturdy-dev/marblezero/state"
 )
 
-func parse(cmd string, ts time.Time) achievements.HistoryEvent {
-	parts := strings.Split(cmd, " ")
-	prog := parts[0]
+type parser struct {
+	aliases  map[string]string
+	commands map[string]state.Command
+	ignore   ignoreRules
+}
 
-	// find subcommand (first non flag)
-	var subcommand string
-	switch prog {
-	case "git",
-		// JS fanboys
-		"npm", "yarn", "pnpm",
-		// Python
-		"pip3", "pip":
-		for _, p := range parts[1:] {
-			if strings.HasPrefix(p, "-") {
-				continue
+func newParser(config *state.Config) (*parser, error) {
+	if config == nil {
+		config = &state.Config{}
+	}
+
+	ignore, err := newIgnoreRules(config.Ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser{
+		aliases:  config.Aliases,
+		commands: mergeCommands(config.Commands),
+		ignore:   ignore,
+	}, nil
+}
+
+// parse returns one event for each simple command in the line, using the
+// default configuration.
+func parse(cmd string, ts time.Time) []achievements.HistoryEvent {
+	p, _ := newParser(nil)
+	return p.parse(cmd, "", ts)
+}
+
+// parse returns one event for each simple command in the line. When a line
+// contains several commands, like pipelines and lists, the events share a group.
+//
+// If the shell has expanded aliases in the line, the expanded form is used for
+// the events, while the typed command names are kept.
+//
+// Lines matching the users ignore rules return no events, and secrets are
+// redacted from all words.
+func (p *parser) parse(cmd, expanded string, ts time.Time) []achievements.HistoryEvent {
+	if p.ignore.line(cmd, expanded) {
+		return nil
+	}
+
+	commands := simpleCommands(p.lex(cmd))
+
+	if expanded != "" && expanded != cmd {
+		if expandedCommands := simpleCommands(p.lex(expanded)); len(expandedCommands) > 0 {
+			if len(expandedCommands) == len(commands) {
+				for i := range commands {
+					expandedCommands[i].typed = commandName(commands[i].words)
+				}
+			}
+			commands = expandedCommands
+		}
+	}
+
+	// commands without a name, like '' or a lone \, don't run anything
+	named := commands[:0]
+	for _, c := range commands {
+		p.expandAliases(&c)
+		if commandName(c.words) == "" {
+			continue
+		}
+		if len(named) == 0 {
+			c.operator = ""
+		}
+		named = append(named, c)
+	}
+	commands = named
+
+	var group string
+	if len(commands) > 1 {
+		group = newGroup()
+	}
+
+	events := make([]achievements.HistoryEvent, 0, len(commands))
+	for _, c := range commands {
+		event := p.parseSimple(c.words, ts)
+		event.Group = group
+		event.Operator = c.operator
+		if c.typed != event.Cmd {
+			event.Typed = c.typed
+		}
+		if p.ignore.event(event) {
+			return nil
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// groups counts the groups of this process, in case no random id can be made
+var groups uint64
+
+// newGroup returns a random id for the events of a line. Lines can't be told
+// apart by when they were run, as imported history only has seconds.
+func newGroup() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x-%d", time.Now().UnixNano(), atomic.AddUint64(&groups, 1))
+	}
+	return hex.EncodeToString(id)
+}
+
+// lex splits the line into tokens, and redacts secrets from the words
+func (p *parser) lex(cmd string) []token {
+	tokens := lex(cmd)
+	for i, t := range tokens {
+		if t.kind == wordToken {
+			tokens[i].text = redact(t.text)
+		}
+	}
+	return tokens
+}
+
+// expandAliases expands the aliases from the users configuration, for shells
+// that can't expand them.
+func (p *parser) expandAliases(c *simpleCommand) {
+	seen := make(map[string]struct{})
+	for len(c.words) > 0 {
+		alias, ok := p.aliases[c.words[0]]
+		if !ok {
+			return
+		}
+		if _, ok := seen[c.words[0]]; ok {
+			return
+		}
+		seen[c.words[0]] = struct{}{}
+
+		if c.typed == "" {
+			c.typed = commandName(c.words)
+		}
+
+		var words []string
+		for _, t := range lex(alias) {
+			if t.kind == wordToken {
+				words = append(words, t.text)
 			}
-			subcommand = p
-			break
 		}
+		c.words = append(words, c.words[1:]...)
+	}
+}
+
+// commandName returns the name of the effective command
+func commandName(words []string) string {
+	words, _ = unwrap(words)
+	if len(words) == 0 || words[0] == "" {
+		return ""
+	}
+	return path.Base(words[0])
+}
+
+func (p *parser) parseSimple(parts []string, ts time.Time) achievements.HistoryEvent {
+	parts, wrapped := unwrap(parts)
+
+	var prog string
+	if len(parts) > 0 {
+		prog = path.Base(parts[0])
+	}
+
+	spec := p.commands[prog]
+
+	var subcommand string
+	if len(parts) > 0 {
+		subcommand = findSubcommand(spec, parts[1:])
 	}
 
 	var exts []string
-	for _, p := range parts {
-		if last := strings.LastIndexByte(p, '.'); last > len(p)-4 && last < len(p)-1 {
-			exts = append(exts, p[last+1:])
+	for _, part := range parts {
+		if ext := fileExtension(part); ext != "" {
+			exts = append(exts, ext)
 		}
 	}
 
 	var flags []string
-	switch prog {
-	case "xcode-select", "rm", "git":
-		for _, p := range parts {
-			if strings.HasPrefix(p, "-") {
-				flags = append(flags, p)
+	if spec.Flags {
+		for _, part := range parts {
+			if strings.HasPrefix(part, "-") && part != "-" {
+				// only the name of --flag=value, its value has been redacted
+				name, _, _ := strings.Cut(part, "=")
+				flags = append(flags, name)
 			}
 		}
 	}
 
+	joined := strings.Join(parts, " ")
+
 	return achievements.HistoryEvent{
 		Cmd:            prog,
 		At:             ts,
 		SubCommand:     subcommand,
 		FileExtensions: exts,
 		Flags:          flags,
+		Wrappers:       wrapped,
 
 		// Deprecated
-		IsForce: strings.Contains(cmd, "--force"),
-		IsRmRf:  strings.Contains(cmd, "-rf") || strings.Contains(cmd, "-fr"),
+		IsForce: strings.Contains(joined, "--force"),
+		IsRmRf:  strings.Contains(joined, "-rf") || strings.Contains(joined, "-fr"),
 	}
 }
 
-func Single(storagePath state.StoragePath, cmd string) error {
-	historyFilePath := path.Join(string(storagePath), "history_wal")
+type simpleCommand struct {
+	words    []string
+	operator string // the control operator before the command, if any
+	typed    string // the name of the command as typed, if it was an alias
+}
+
+// simpleCommands splits a line into simple commands at pipes, lists and
+// subshells. Leading variable assignments, redirections and reserved words
+// are removed.
+func simpleCommands(tokens []token) []simpleCommand {
+	var commands []simpleCommand
+	var current simpleCommand
+	var operator string
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		switch t.kind {
+		case operatorToken:
+			if len(current.words) > 0 {
+				commands = append(commands, current)
+				current = simpleCommand{}
+			}
+			// parentheses only group commands, they don't connect them
+			if t.text != "(" && t.text != ")" {
+				operator = t.text
+			}
+		case redirectToken:
+			if redirectHasTarget(t.text) {
+				i++
+			}
+		case wordToken:
+			if len(current.words) == 0 {
+				if t.assignment {
+					continue
+				}
+				if _, ok := reservedWords[t.text]; ok {
+					continue
+				}
+				current.operator = operator
+			}
+			current.words = append(current.words, t.text)
+		}
+	}
+	if len(current.words) > 0 {
+		commands = append(commands, current)
+	}
+
+	// The header of a for loop or case statement is not a command
+	filtered := commands[:0]
+	for _, c := range commands {
+		switch c.words[0] {
+		case "for", "select", "case":
+			continue
+		}
+		filtered = append(filtered, c)
+	}
+	if len(filtered) > 0 {
+		filtered[0].operator = ""
+	}
+
+	return filtered
+}
+
+var reservedWords = map[string]struct{}{
+	"!": {}, "{": {}, "}": {},
+	"if": {}, "then": {}, "elif": {}, "else": {}, "fi": {},
+	"while": {}, "until": {}, "do": {}, "done": {}, "esac": {},
+}
 
-	fp, err := os.OpenFile(historyFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+// redirectHasTarget reports if the redirection is followed by a word, which
+// is not the case for duplications like 2>&1 and >&-.
+func redirectHasTarget(op string) bool {
+	if i := strings.LastIndexByte(op, '&'); i >= 0 && i > strings.LastIndexAny(op, "<>") {
+		return i == len(op)-1
+	}
+	return true
+}
+
+// fileExtension returns the extension of a word that looks like a file name,
+// extensions are between 1 and 3 alphanumeric characters.
+func fileExtension(word string) string {
+	last := strings.LastIndexByte(word, '.')
+	if last < 0 || last < len(word)-4 || last == len(word)-1 {
+		return ""
+	}
+	ext := word[last+1:]
+	for _, c := range ext {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ext
+}
+
+// Execution is a finished command, as reported by a shell post-exec hook.
+type Execution struct {
+	Cmd      string
+	Expanded string // Cmd with aliases expanded, if the shell supports it
+	ExitCode int
+	Duration time.Duration
+	Cwd      string
+}
+
+func Single(storagePath state.StoragePath, config *state.Config, cmd string) error {
+	p, err := newParser(config)
 	if err != nil {
-		return fmt.Errorf("failed to open wal: %w", err)
+		return err
+	}
+	return write(storagePath, p.parse(cmd, "", time.Now()))
+}
+
+// Complete imports a command after it has finished. The event is timestamped
+// with the time that the command was started.
+func Complete(storagePath state.StoragePath, config *state.Config, exec Execution) error {
+	p, err := newParser(config)
+	if err != nil {
+		return err
 	}
 
-	ts := time.Now()
+	events := p.parse(exec.Cmd, exec.Expanded, time.Now().Add(-exec.Duration))
+
+	exitCode := exec.ExitCode
+	setOutcome(events, &exitCode, exec.Duration, exec.Cwd)
+
+	return write(storagePath, events)
+}
+
+// setOutcome sets the outcome of a line on its events. Shells only report the
+// outcome of the line as a whole, which is the outcome of the last command.
+func setOutcome(events []achievements.HistoryEvent, exitCode *int, duration time.Duration, cwd string) {
+	if len(events) == 0 {
+		return
+	}
 
-	event := parse(cmd, ts)
+	for i := range events {
+		events[i].Cwd = cwd
+	}
 
-	raw, err := json.Marshal(event)
+	events[len(events)-1].ExitCode = exitCode
+	events[len(events)-1].Duration = duration
+}
+
+func write(storagePath state.StoragePath, events []achievements.HistoryEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	historyFilePath := path.Join(string(storagePath), "history_wal")
+
+	// all events of a line are written at once
+	var buf bytes.Buffer
+	for _, event := range events {
+		redactEvent(&event)
+		raw, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal json: %w", err)
+		}
+		buf.Write(raw)
+		buf.WriteByte('\n')
+	}
+
+	// other shells, and the tui, might be using the wal at the same time
+	fp, err := state.OpenLocked(historyFilePath, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0664, true)
 	if err != nil {
-		return fmt.Errorf("failed to marshal json: %w", err)
+		return fmt.Errorf("failed to open wal: %w", err)
 	}
 
-	if _, err := fp.WriteString(string(raw) + "\n"); err != nil {
-		return fmt.Errorf("failed to write: %w", err)
+	err = appendLines(fp, buf.Bytes())
+	if closeErr := fp.Close(); err == nil && closeErr != nil {
+		return fmt.Errorf("failed to close wal: %w", closeErr)
 	}
+	return err
+}
 
-	if err := fp.Close(); err != nil {
-		return fmt.Errorf("failed to close file")
+// appendLines writes the lines at the end of the locked wal
+func appendLines(fp *os.File, lines []byte) error {
+	// if an earlier write was interrupted, start on a new line to not corrupt
+	// this one as well
+	if torn, err := endsWithTornLine(fp); err != nil {
+		return err
+	} else if torn {
+		if _, err := fp.Write([]byte{'\n'}); err != nil {
+			return fmt.Errorf("failed to write: %w", err)
+		}
 	}
 
+	if _, err := fp.Write(lines); err != nil {
+		return fmt.Errorf("failed to write: %w", err)
+	}
 	return nil
 }
+
+// endsWithTornLine reports if the last line of the file is missing its newline
+func endsWithTornLine(fp *os.File) (bool, error) {
+	info, err := fp.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat wal: %w", err)
+	}
+	if info.Size() == 0 {
+		return false, nil
+	}
+
+	last := make([]byte, 1)
+	if _, err := fp.ReadAt(last, info.Size()-1); err != nil {
+		return false, fmt.Errorf("failed to read wal: %w", err)
+	}
+	return last[0] != '\n', nil
+}
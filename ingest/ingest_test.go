@@ -1,45 +1,845 @@
 package ingest
 
 import (
+	"os"
+	"path"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/sturdy-dev/hackagotchi/achievements"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/achievements"
+	"github.com/sturdy-dev/marblezero/state"
 )
 
 func TestParse(t *testing.T) {
 	ts := time.Now()
+	const group = "group"
 
 	cases := []struct {
 		cmd      string
-		expected achievements.HistoryEvent
+		expected []achievements.HistoryEvent
 	}{
 		{
 			cmd: "git add foo.go bar.go yes.py lalalalalalalallaa .DS_Store hello. what.s",
-			expected: achievements.HistoryEvent{
+			expected: []achievements.HistoryEvent{{
 				Cmd:            "git",
 				SubCommand:     "add",
 				FileExtensions: []string{"go", "go", "py", "s"},
 				At:             ts,
-			},
+			}},
 		},
 		{
 			cmd: "git push --force",
-			expected: achievements.HistoryEvent{
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "push",
+				Flags:      []string{"--force"},
+				IsForce:    true,
+				At:         ts,
+			}},
+		},
+		{
+			cmd:      "",
+			expected: nil,
+		},
+		{
+			cmd:      "   ",
+			expected: nil,
+		},
+		{
+			cmd: "git push --force-with-lease=origin/main --push-option=ci.skip",
+			expected: []achievements.HistoryEvent{{
 				Cmd:        "git",
 				SubCommand: "push",
+				Flags:      []string{"--force-with-lease", "--push-option"},
 				IsForce:    true,
 				At:         ts,
+			}},
+		},
+		{
+			cmd: "git    commit     -m    wip",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				Flags:      []string{"-m"},
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "\tgit\tstatus\t",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "status",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: `git commit -m "fix: handle foo.go and bar"`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				Flags:      []string{"-m"},
+				At:         ts,
+			}},
+		},
+		{
+			cmd: `git commit -m 'it'"'"'s done'`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				Flags:      []string{"-m"},
+				At:         ts,
+			}},
+		},
+		{
+			cmd: `git add "my file.go" 'other file.rs'`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:            "git",
+				SubCommand:     "add",
+				FileExtensions: []string{"go", "rs"},
+				At:             ts,
+			}},
+		},
+		{
+			cmd: `git add my\ file.go`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:            "git",
+				SubCommand:     "add",
+				FileExtensions: []string{"go"},
+				At:             ts,
+			}},
+		},
+		{
+			cmd: `"git" status`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "status",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: `\git status`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "status",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "/usr/bin/git status",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "status",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "./gradlew build",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "gradlew",
+				SubCommand: "build",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "FOO=1 go test ./...",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "go",
+				SubCommand: "test",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "FOO=1 BAR='a b' CGO_ENABLED=0 go build",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "go",
+				SubCommand: "build",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: `"FOO=1" go test`,
+			expected: []achievements.HistoryEvent{{
+				Cmd: "FOO=<redacted>",
+				At:  ts,
+			}},
+		},
+		{
+			cmd:      "FOO=1",
+			expected: nil,
+		},
+		{
+			cmd: "git commit -m $(cat msg.txt)",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				Flags:      []string{"-m"},
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "git checkout $(git rev-parse --abbrev-ref HEAD | sed 's/ /-/g')",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "checkout",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "echo `date +%s` ${HOME} $PWD",
+			expected: []achievements.HistoryEvent{{
+				Cmd: "echo",
+				At:  ts,
+			}},
+		},
+		{
+			cmd: `git commit -m "$(date) \"quoted\" \$HOME"`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				Flags:      []string{"-m"},
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "go test ./... > out.log 2>&1",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "go",
+				SubCommand: "test",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "cat < input.txt",
+			expected: []achievements.HistoryEvent{{
+				Cmd: "cat",
+				At:  ts,
+			}},
+		},
+		{
+			cmd: "diff <(sort a.txt) <(sort b.txt)",
+			expected: []achievements.HistoryEvent{{
+				Cmd: "diff",
+				At:  ts,
+			}},
+		},
+		{
+			cmd: "vim main.go # fix the bug",
+			expected: []achievements.HistoryEvent{{
+				Cmd:            "vim",
+				FileExtensions: []string{"go"},
+				At:             ts,
+			}},
+		},
+		{
+			cmd: "echo a#b",
+			expected: []achievements.HistoryEvent{{
+				Cmd: "echo",
+				At:  ts,
+			}},
+		},
+		{
+			cmd: "rm -rf ./build",
+			expected: []achievements.HistoryEvent{{
+				Cmd:    "rm",
+				Flags:  []string{"-rf"},
+				IsRmRf: true,
+				At:     ts,
+			}},
+		},
+		{
+			cmd: "rm -- -rf",
+			expected: []achievements.HistoryEvent{{
+				Cmd:    "rm",
+				Flags:  []string{"--", "-rf"},
+				IsRmRf: true,
+				At:     ts,
+			}},
+		},
+		{
+			cmd: "npm --silent install left-pad",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "npm",
+				SubCommand: "install",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "pip3 install -r requirements.txt",
+			expected: []achievements.HistoryEvent{{
+				Cmd:            "pip3",
+				SubCommand:     "install",
+				FileExtensions: []string{"txt"},
+				At:             ts,
+			}},
+		},
+		{
+			cmd: "curl https://example.com/a/b ../c",
+			expected: []achievements.HistoryEvent{{
+				Cmd: "curl",
+				At:  ts,
+			}},
+		},
+		{
+			cmd: "python3 .py",
+			expected: []achievements.HistoryEvent{{
+				Cmd:            "python3",
+				FileExtensions: []string{"py"},
+				At:             ts,
+			}},
+		},
+		{
+			cmd: "cat file.json",
+			expected: []achievements.HistoryEvent{{
+				Cmd: "cat",
+				At:  ts,
+			}},
+		},
+		{
+			cmd: `git commit -m "unterminated`,
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				Flags:      []string{"-m"},
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "git commit -m $(echo 'unterminated",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				Flags:      []string{"-m"},
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "git \\\ncommit",
+			expected: []achievements.HistoryEvent{{
+				Cmd:        "git",
+				SubCommand: "commit",
+				At:         ts,
+			}},
+		},
+		{
+			cmd: "git status | less",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "status", At: ts, Group: group},
+				{Cmd: "less", At: ts, Group: group, Operator: "|"},
+			},
+		},
+		{
+			cmd: "git status|less",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "status", At: ts, Group: group},
+				{Cmd: "less", At: ts, Group: group, Operator: "|"},
+			},
+		},
+		{
+			cmd: "git add . && git commit -m x | tee log",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "add", At: ts, Group: group},
+				{Cmd: "git", SubCommand: "commit", Flags: []string{"-m"}, At: ts, Group: group, Operator: "&&"},
+				{Cmd: "tee", At: ts, Group: group, Operator: "|"},
+			},
+		},
+		{
+			cmd: "make || echo failed; ls",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "make", At: ts, Group: group},
+				{Cmd: "echo", At: ts, Group: group, Operator: "||"},
+				{Cmd: "ls", At: ts, Group: group, Operator: ";"},
+			},
+		},
+		{
+			cmd: "go test ./... 2>&1 | grep FAIL",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "go", SubCommand: "test", At: ts, Group: group},
+				{Cmd: "grep", At: ts, Group: group, Operator: "|"},
+			},
+		},
+		{
+			cmd: "(cd web && npm install) & wait",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cd", At: ts, Group: group},
+				{Cmd: "npm", SubCommand: "install", At: ts, Group: group, Operator: "&&"},
+				{Cmd: "wait", At: ts, Group: group, Operator: "&"},
+			},
+		},
+		{
+			cmd: "{ git fetch; git rebase; }",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "fetch", At: ts, Group: group},
+				{Cmd: "git", SubCommand: "rebase", At: ts, Group: group, Operator: ";"},
+			},
+		},
+		{
+			cmd: "if make; then ./app; fi",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "make", At: ts, Group: group},
+				{Cmd: "app", At: ts, Group: group, Operator: ";"},
+			},
+		},
+		{
+			cmd: "for f in *.go; do gofmt -l $f; done",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "gofmt", At: ts},
+			},
+		},
+		{
+			cmd: "git commit -m 'a && b | c; d'",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "commit", Flags: []string{"-m"}, At: ts},
+			},
+		},
+		{
+			cmd: "git log |& less",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "log", At: ts, Group: group},
+				{Cmd: "less", At: ts, Group: group, Operator: "|&"},
+			},
+		},
+		{
+			cmd: "ls ;; ; &&",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "ls", At: ts},
+			},
+		},
+		{
+			cmd: "git pull\ngo build",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "pull", At: ts, Group: group},
+				{Cmd: "go", SubCommand: "build", At: ts, Group: group, Operator: ";"},
+			},
+		},
+		{
+			cmd: "sudo docker ps",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "docker", SubCommand: "ps", Wrappers: []string{"sudo"}, At: ts},
+			},
+		},
+		{
+			cmd: "sudo -u postgres -E psql",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "psql", Wrappers: []string{"sudo"}, At: ts},
+			},
+		},
+		{
+			cmd: "sudo -i",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "sudo", At: ts},
+			},
+		},
+		{
+			cmd: "sudo -l",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "sudo", At: ts},
+			},
+		},
+		{
+			cmd: "doas rm -rf /tmp/build",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "rm", Flags: []string{"-rf"}, IsRmRf: true, Wrappers: []string{"doas"}, At: ts},
+			},
+		},
+		{
+			cmd: "env -u HOME GOOS=linux GOARCH=arm64 go build",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "go", SubCommand: "build", Wrappers: []string{"env"}, At: ts},
+			},
+		},
+		{
+			cmd: "/usr/bin/env python3 script.py",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "python3", FileExtensions: []string{"py"}, Wrappers: []string{"env"}, At: ts},
+			},
+		},
+		{
+			cmd: "env",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "env", At: ts},
+			},
+		},
+		{
+			cmd: "time -p cargo build",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cargo", SubCommand: "build", Wrappers: []string{"time"}, At: ts},
+			},
+		},
+		{
+			cmd: "nice -n 19 nohup make",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "make", Wrappers: []string{"nice", "nohup"}, At: ts},
+			},
+		},
+		{
+			cmd: "exec -a name zsh",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "zsh", Wrappers: []string{"exec"}, At: ts},
+			},
+		},
+		{
+			cmd: "command git status",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "status", Wrappers: []string{"command"}, At: ts},
+			},
+		},
+		{
+			cmd: "command -v git",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "command", At: ts},
+			},
+		},
+		{
+			cmd: "find . -name '*.bak' | xargs -n 1 -I {} rm {}",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "find", FileExtensions: []string{"bak"}, At: ts, Group: group},
+				{Cmd: "rm", Wrappers: []string{"xargs"}, At: ts, Group: group, Operator: "|"},
+			},
+		},
+		{
+			cmd: "watch -n 2 kubectl get pods",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "kubectl", SubCommand: "get", Wrappers: []string{"watch"}, At: ts},
+			},
+		},
+		{
+			cmd: "sudo -- sudo env FOO=1 git push --force",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "push", Flags: []string{"--force"}, IsForce: true, Wrappers: []string{"sudo", "sudo", "env"}, At: ts},
+			},
+		},
+		{
+			cmd: "docker compose -f dev.yml up -d",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "docker", SubCommand: "compose up", Flags: []string{"-f", "-d"}, FileExtensions: []string{"yml"}, At: ts},
+			},
+		},
+		{
+			cmd: "docker --context prod image ls",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "docker", SubCommand: "image ls", Flags: []string{"--context"}, At: ts},
+			},
+		},
+		{
+			cmd: "kubectl -n kube-system apply -f .",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "kubectl", SubCommand: "apply", Flags: []string{"-n", "-f"}, At: ts},
+			},
+		},
+		{
+			cmd: "cargo test --release",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cargo", SubCommand: "test", Flags: []string{"--release"}, At: ts},
+			},
+		},
+		{
+			cmd: "go test -race ./...",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "go", SubCommand: "test", Flags: []string{"-race"}, At: ts},
+			},
+		},
+		{
+			cmd: "go mod tidy",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "go", SubCommand: "mod tidy", At: ts},
+			},
+		},
+		{
+			cmd: "git -C ../other stash pop",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "stash pop", Flags: []string{"-C"}, At: ts},
+			},
+		},
+		{
+			cmd: "aws --profile dev s3 cp a.txt s3://bucket/",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "aws", SubCommand: "s3 cp", FileExtensions: []string{"txt"}, At: ts},
+			},
+		},
+		{
+			cmd: "gcloud compute instances list",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "gcloud", SubCommand: "compute instances list", At: ts},
+			},
+		},
+		{
+			cmd: "npm run build",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "npm", SubCommand: "run build", At: ts},
+			},
+		},
+		{
+			cmd: "ls -la",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "ls", At: ts},
+			},
+		},
+		{
+			cmd: "cat <<EOF\nhello world\nrm -rf /\nEOF",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cat", At: ts},
+			},
+		},
+		{
+			cmd: "cat <<-'EOF' | grep x\n\trm -rf /\n\tEOF\ngit status",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cat", At: ts, Group: group},
+				{Cmd: "grep", At: ts, Group: group, Operator: "|"},
+				{Cmd: "git", SubCommand: "status", At: ts, Group: group, Operator: ";"},
+			},
+		},
+		{
+			cmd: "cat <<A 3<<\"B\"\nrm -rf /\nA\nrm -rf /\nB",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cat", At: ts},
+			},
+		},
+		{
+			cmd: "cat <<EOF\nrm -rf /",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cat", At: ts},
+			},
+		},
+		{
+			cmd: "cat <<< 'rm -rf /'",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "cat", At: ts},
+			},
+		},
+		{
+			cmd:      "''",
+			expected: nil,
+		},
+		{
+			cmd:      "\\",
+			expected: nil,
+		},
+		{
+			cmd:      "'",
+			expected: nil,
+		},
+		{
+			cmd: "'' && ls; sudo \"\"",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "ls", At: ts},
+			},
+		},
+		{
+			cmd: ". ./env.sh",
+			expected: []achievements.HistoryEvent{
+				{Cmd: ".", FileExtensions: []string{"sh"}, At: ts},
 			},
 		},
 	}
 
+	// lines with leading whitespace are ignored by default, see TestIgnore
+	no := false
+	p, err := newParser(&state.Config{Ignore: state.Ignore{LeadingSpace: &no}})
+	require.NoError(t, err)
+
 	for _, tc := range cases {
 		t.Run(tc.cmd, func(t *testing.T) {
-			event := parse(tc.cmd, ts)
-			assert.Equal(t, tc.expected, event)
+			events := sameGroup(t, p.parse(tc.cmd, "", ts), group)
+			if tc.expected == nil {
+				assert.Empty(t, events)
+				return
+			}
+			assert.Equal(t, tc.expected, events)
 		})
 	}
 
 }
+
+// sameGroup checks that the events of a line share one group, and renames it
+// so that the events can be compared
+func sameGroup(t *testing.T, events []achievements.HistoryEvent, name string) []achievements.HistoryEvent {
+	t.Helper()
+	var group string
+	for i := range events {
+		if events[i].Group == "" {
+			continue
+		}
+		if group == "" {
+			group = events[i].Group
+		}
+		assert.Equal(t, group, events[i].Group)
+		events[i].Group = name
+	}
+	return events
+}
+
+func TestParseGroups(t *testing.T) {
+	ts := time.Unix(1668000000, 0)
+
+	// the same line imported twice in the same second
+	first := parse("git status | less", ts)
+	second := parse("git status | less", ts)
+	require.Len(t, first, 2)
+	require.Len(t, second, 2)
+	assert.NotEmpty(t, first[0].Group)
+	assert.NotEqual(t, first[0].Group, second[0].Group)
+	assert.Len(t, achievements.Lines(append(first, second...)), 2)
+}
+
+func TestComplete(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+
+	// A line written by an older version, without outcome fields
+	old := `{"cmd":"go","at":"2022-11-01T10:00:00Z","subcommand":"","flags":null}` + "\n"
+	require.NoError(t, os.WriteFile(path.Join(string(storagePath), "history_wal"), []byte(old), 0664))
+
+	require.NoError(t, Complete(storagePath, nil, Execution{
+		Cmd:      "go test ./...",
+		ExitCode: 1,
+		Duration: 90 * time.Second,
+		Cwd:      "/src/marblezero",
+	}))
+
+	events, err := achievements.ParseHistory(storagePath)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	assert.Equal(t, "go", events[0].Cmd)
+	assert.Nil(t, events[0].ExitCode)
+
+	assert.Equal(t, "go", events[1].Cmd)
+	require.NotNil(t, events[1].ExitCode)
+	assert.Equal(t, 1, *events[1].ExitCode)
+	assert.Equal(t, 90*time.Second, events[1].Duration)
+	assert.Equal(t, "/src/marblezero", events[1].Cwd)
+	assert.WithinDuration(t, time.Now().Add(-90*time.Second), events[1].At, 5*time.Second)
+}
+
+func TestParseAliases(t *testing.T) {
+	ts := time.Now()
+	const group = "group"
+
+	p, err := newParser(&state.Config{
+		Aliases: map[string]string{
+			"k":   "kubectl",
+			"kgp": "k get pods",
+			"gs":  "git status",
+			"x":   "y",
+			"y":   "x",
+		},
+	})
+	require.NoError(t, err)
+
+	cases := []struct {
+		cmd      string
+		expanded string
+		expected []achievements.HistoryEvent
+	}{
+		{
+			cmd:      "g commit -m wip && g push",
+			expanded: "git commit -m wip && git push",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", Typed: "g", SubCommand: "commit", Flags: []string{"-m"}, At: ts, Group: group},
+				{Cmd: "git", Typed: "g", SubCommand: "push", At: ts, Group: group, Operator: "&&"},
+			},
+		},
+		{
+			cmd:      "git status",
+			expanded: "git status",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", SubCommand: "status", At: ts},
+			},
+		},
+		{
+			cmd: "k apply -f deploy.yml",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "kubectl", Typed: "k", SubCommand: "apply", Flags: []string{"-f"}, FileExtensions: []string{"yml"}, At: ts},
+			},
+		},
+		{
+			cmd: "kgp -w",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "kubectl", Typed: "kgp", SubCommand: "get", Flags: []string{"-w"}, At: ts},
+			},
+		},
+		{
+			// like in a shell, aliases are only expanded at the start of a command
+			cmd: "sudo gs",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "gs", Wrappers: []string{"sudo"}, At: ts},
+			},
+		},
+		{
+			cmd: "gs | cat",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", Typed: "gs", SubCommand: "status", At: ts, Group: group},
+				{Cmd: "cat", At: ts, Group: group, Operator: "|"},
+			},
+		},
+		{
+			cmd: "x",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "x", At: ts},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.cmd, func(t *testing.T) {
+			assert.Equal(t, tc.expected, sameGroup(t, p.parse(tc.cmd, tc.expanded, ts), group))
+		})
+	}
+}
+
+func TestParseConfiguredCommands(t *testing.T) {
+	ts := time.Now()
+
+	p, err := newParser(&state.Config{
+		Commands: map[string]state.Command{
+			"ourctl": {
+				Subcommands: 1,
+				Flags:       true,
+				ValueFlags:  []string{"--env"},
+				Nested: map[string]state.Command{
+					"db": {Subcommands: 1},
+				},
+			},
+			// overrides the default
+			"git": {},
+		},
+	})
+	require.NoError(t, err)
+
+	cases := []struct {
+		cmd      string
+		expected []achievements.HistoryEvent
+	}{
+		{
+			cmd: "ourctl --env prod deploy --canary",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "ourctl", SubCommand: "deploy", Flags: []string{"--env", "--canary"}, At: ts},
+			},
+		},
+		{
+			cmd: "ourctl db migrate",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "ourctl", SubCommand: "db migrate", At: ts},
+			},
+		},
+		{
+			cmd: "git push --force",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "git", IsForce: true, At: ts},
+			},
+		},
+		{
+			cmd: "docker ps",
+			expected: []achievements.HistoryEvent{
+				{Cmd: "docker", SubCommand: "ps", At: ts},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.cmd, func(t *testing.T) {
+			assert.Equal(t, tc.expected, p.parse(tc.cmd, "", ts))
+		})
+	}
+}
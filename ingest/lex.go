@@ -0,0 +1,377 @@
+package ingest
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	wordToken     tokenKind = iota
+	operatorToken           // control operators such as |, && and ;
+	redirectToken           // redirections such as >, 2>&1 and <<
+)
+
+type token struct {
+	kind tokenKind
+	text string // words are unquoted and unescaped
+
+	// assignment is set for words on the form NAME=value, where NAME is not quoted
+	assignment bool
+}
+
+// lex splits a command line into words and operators, roughly following the
+// POSIX shell grammar. Quotes and backslash escapes are removed from words,
+// while command substitutions and parameter expansions are kept verbatim.
+//
+// Here-documents are skipped, their bodies are neither words nor commands.
+//
+// lex never fails, unterminated quotes, substitutions and here-documents run
+// until the end of the line.
+func lex(cmd string) []token {
+	l := lexer{input: cmd}
+	l.run()
+	return l.tokens
+}
+
+type lexer struct {
+	input string
+	pos   int
+
+	tokens []token
+
+	word       strings.Builder
+	inWord     bool
+	quoted     bool // part of the current word was quoted or escaped
+	assignment bool
+
+	// heredoc is set after << and <<-, until their delimiter has been read
+	heredoc *heredoc
+	// heredocs that start on the next line, in order
+	heredocs []heredoc
+}
+
+type heredoc struct {
+	delimiter string
+	stripTabs bool // for <<-
+}
+
+func (l *lexer) run() {
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+			l.endWord()
+
+		case c == '\n':
+			l.pos++
+			l.endWord()
+			l.emit(operatorToken, ";")
+			l.heredocBodies()
+
+		case c == '#' && !l.inWord:
+			// comment, until end of line
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+
+		case c == '\\':
+			l.inWord = true
+			if l.pos+1 < len(l.input) {
+				if l.input[l.pos+1] != '\n' { // line continuation
+					l.quoted = true
+					l.word.WriteByte(l.input[l.pos+1])
+				}
+				l.pos += 2
+			} else {
+				l.pos++
+			}
+
+		case c == '\'':
+			l.inWord = true
+			l.quoted = true
+			end := strings.IndexByte(l.input[l.pos+1:], '\'')
+			if end < 0 {
+				l.word.WriteString(l.input[l.pos+1:])
+				l.pos = len(l.input)
+			} else {
+				l.word.WriteString(l.input[l.pos+1 : l.pos+1+end])
+				l.pos += end + 2
+			}
+
+		case c == '"':
+			l.inWord = true
+			l.quoted = true
+			l.pos++
+			l.doubleQuoted()
+
+		case c == '$' || c == '`':
+			l.inWord = true
+			start := l.pos
+			l.substitution()
+			l.word.WriteString(l.input[start:l.pos])
+
+		case c == '=' && l.inWord && !l.quoted && !l.assignment && isName(l.word.String()):
+			l.assignment = true
+			l.word.WriteByte(c)
+			l.pos++
+
+		case (c == '<' || c == '>') && l.peek() == '(':
+			// process substitution
+			l.inWord = true
+			start := l.pos
+			l.pos++
+			l.balanced('(', ')')
+			l.word.WriteString(l.input[start:l.pos])
+
+		case c == '>' || c == '<' || (c == '&' && l.peek() == '>'):
+			l.redirect()
+
+		case c == '|' || c == '&' || c == ';' || c == '(' || c == ')':
+			l.endWord()
+			l.operator()
+
+		default:
+			l.inWord = true
+			l.word.WriteByte(c)
+			l.pos++
+		}
+	}
+	l.endWord()
+}
+
+func (l *lexer) peek() byte {
+	if l.pos+1 < len(l.input) {
+		return l.input[l.pos+1]
+	}
+	return 0
+}
+
+func (l *lexer) emit(kind tokenKind, text string) {
+	l.tokens = append(l.tokens, token{kind: kind, text: text})
+}
+
+func (l *lexer) endWord() {
+	if l.inWord {
+		l.tokens = append(l.tokens, token{kind: wordToken, text: l.word.String(), assignment: l.assignment})
+		if l.heredoc != nil {
+			l.heredoc.delimiter = l.word.String()
+			l.heredocs = append(l.heredocs, *l.heredoc)
+			l.heredoc = nil
+		}
+	}
+	l.word.Reset()
+	l.inWord = false
+	l.quoted = false
+	l.assignment = false
+}
+
+// doubleQuoted consumes the contents of a double quoted string, the opening
+// quote has already been consumed.
+func (l *lexer) doubleQuoted() {
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return
+		case '\\':
+			// inside double quotes, backslash only escapes a few characters
+			if l.pos+1 < len(l.input) && strings.IndexByte("$`\"\\\n", l.input[l.pos+1]) >= 0 {
+				if l.input[l.pos+1] != '\n' {
+					l.word.WriteByte(l.input[l.pos+1])
+				}
+				l.pos += 2
+				continue
+			}
+			l.word.WriteByte(c)
+			l.pos++
+		case '$', '`':
+			start := l.pos
+			l.substitution()
+			l.word.WriteString(l.input[start:l.pos])
+		default:
+			l.word.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+// substitution skips over a $(...), $((...)), ${...}, `...` or $NAME.
+func (l *lexer) substitution() {
+	if l.input[l.pos] == '`' {
+		l.pos++
+		for l.pos < len(l.input) {
+			switch l.input[l.pos] {
+			case '\\':
+				l.pos += 2
+				continue
+			case '`':
+				l.pos++
+				return
+			}
+			l.pos++
+		}
+		l.pos = len(l.input)
+		return
+	}
+
+	l.pos++ // $
+	if l.pos >= len(l.input) {
+		return
+	}
+
+	switch l.input[l.pos] {
+	case '(':
+		l.balanced('(', ')')
+	case '{':
+		l.balanced('{', '}')
+	default:
+		for l.pos < len(l.input) && isNameByte(l.input[l.pos]) {
+			l.pos++
+		}
+	}
+}
+
+// balanced skips until the bracket at the current position is closed,
+// respecting quotes and nesting.
+func (l *lexer) balanced(open, close byte) {
+	depth := 0
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch c {
+		case '\\':
+			l.pos += 2
+			continue
+		case '\'':
+			if end := strings.IndexByte(l.input[l.pos+1:], '\''); end >= 0 {
+				l.pos += end + 2
+				continue
+			}
+			l.pos = len(l.input)
+			return
+		case '"':
+			l.pos++
+			for l.pos < len(l.input) && l.input[l.pos] != '"' {
+				if l.input[l.pos] == '\\' {
+					l.pos++
+				}
+				l.pos++
+			}
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				l.pos++
+				return
+			}
+		}
+		l.pos++
+	}
+	l.pos = len(l.input)
+}
+
+func (l *lexer) redirect() {
+	// a word made up of only digits is a file descriptor, as in 2>&1
+	if l.inWord && (l.quoted || !isDigits(l.word.String())) {
+		l.endWord()
+	}
+
+	start := l.pos
+	if l.inWord {
+		start -= l.word.Len()
+	}
+	l.word.Reset()
+	l.inWord = false
+
+	l.pos++
+	for l.pos < len(l.input) && strings.IndexByte("<>&|-", l.input[l.pos]) >= 0 {
+		l.pos++
+	}
+	// the target of >&2 and <&- is part of the operator
+	if strings.HasSuffix(l.input[start:l.pos], "&") {
+		for l.pos < len(l.input) && (isDigits(l.input[l.pos:l.pos+1]) || l.input[l.pos] == '-') {
+			l.pos++
+		}
+	}
+
+	op := l.input[start:l.pos]
+	l.emit(redirectToken, op)
+
+	switch strings.TrimLeft(op, "0123456789") {
+	case "<<":
+		l.heredoc = &heredoc{}
+	case "<<-":
+		l.heredoc = &heredoc{stripTabs: true}
+	}
+}
+
+// heredocBodies skips the bodies of the here-documents that were started on
+// the line that just ended, each until the line with its delimiter.
+func (l *lexer) heredocBodies() {
+	for _, h := range l.heredocs {
+		for l.pos < len(l.input) {
+			end := strings.IndexByte(l.input[l.pos:], '\n')
+			if end < 0 {
+				end = len(l.input) - l.pos
+			}
+			line := l.input[l.pos : l.pos+end]
+			l.pos += end + 1
+			if h.stripTabs {
+				line = strings.TrimLeft(line, "\t")
+			}
+			if line == h.delimiter {
+				break
+			}
+		}
+	}
+	if l.pos > len(l.input) {
+		l.pos = len(l.input)
+	}
+	l.heredocs = nil
+}
+
+func (l *lexer) operator() {
+	c := l.input[l.pos]
+	l.pos++
+	if l.pos < len(l.input) {
+		next := l.input[l.pos]
+		if (c == '|' && (next == '|' || next == '&')) || (c == '&' && next == '&') || (c == ';' && next == ';') {
+			l.pos++
+			l.emit(operatorToken, string([]byte{c, next}))
+			return
+		}
+	}
+	l.emit(operatorToken, string(c))
+}
+
+func isName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
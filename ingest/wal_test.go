@@ -0,0 +1,122 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/achievements"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+func TestConcurrentWrites(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+
+	const writers, lines = 16, 50
+	at := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				cmd := fmt.Sprintf("git commit -m 'writer %d line %d' && go test ./... | tee out.log", w, i)
+				assert.NoError(t, write(storagePath, parse(cmd, at)))
+			}
+		}(w)
+	}
+
+	// read while writing, nothing should be half written
+	for i := 0; i < 20; i++ {
+		_, err := achievements.ParseHistory(storagePath)
+		require.NoError(t, err)
+	}
+
+	wg.Wait()
+
+	events, err := achievements.ParseHistory(storagePath)
+	require.NoError(t, err)
+	assert.Len(t, events, writers*lines*3)
+}
+
+func TestWritesDuringCompaction(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+
+	const old, writers, lines = 400, 8, 50
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < old; i++ {
+		require.NoError(t, write(storagePath, parse("git status", start.Add(time.Duration(i)*time.Second))))
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				assert.NoError(t, write(storagePath, parse("go test ./...", time.Now())))
+			}
+		}()
+	}
+
+	// compact while writing, no events should get lost
+	for i := 1; i <= 20; i++ {
+		engine, err := achievements.NewEngine(storagePath, achievements.Achievements)
+		require.NoError(t, err)
+		_, err = engine.Compact(start.Add(time.Duration(i*20) * time.Second))
+		require.NoError(t, err)
+	}
+
+	wg.Wait()
+
+	engine, err := achievements.NewEngine(storagePath, achievements.Achievements)
+	require.NoError(t, err)
+	require.NoError(t, engine.Update())
+	assert.Equal(t, old+writers*lines, engine.Len())
+
+	wal, err := achievements.ParseHistory(storagePath)
+	require.NoError(t, err)
+	var kept int
+	for _, e := range wal {
+		if e.Cmd == "git" {
+			kept++
+		}
+	}
+	// the last processed line is kept as a checkpoint, which might be old
+	assert.LessOrEqual(t, kept, 1)
+}
+
+func TestCorruptLines(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	walPath := path.Join(string(storagePath), "history_wal")
+
+	require.NoError(t, write(storagePath, parse("git status", time.Now())))
+
+	// a write that was interrupted halfway
+	fp, err := os.OpenFile(walPath, os.O_APPEND|os.O_WRONLY, 0664)
+	require.NoError(t, err)
+	_, err = fp.WriteString(`{"cmd":"git","at":"20`)
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	require.NoError(t, write(storagePath, parse("go build", time.Now())))
+
+	events, err := achievements.ParseHistory(storagePath)
+
+	var corrupt *achievements.CorruptError
+	require.True(t, errors.As(err, &corrupt))
+	require.Len(t, corrupt.Lines, 1)
+	assert.Equal(t, 2, corrupt.Lines[0].Line)
+
+	// the events around the corrupt line are kept
+	require.Len(t, events, 2)
+	assert.Equal(t, "git", events[0].Cmd)
+	assert.Equal(t, "go", events[1].Cmd)
+}
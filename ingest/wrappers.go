@@ -0,0 +1,107 @@
+package ingest
+
+import (
+	"path"
+	"strings"
+)
+
+type wrapper struct {
+	// flags that take their value as a separate argument
+	valueFlags []string
+
+	// flags that mean that no command is run, like command -v
+	stopFlags []string
+
+	// leading NAME=value arguments are part of the wrapper, as for env
+	assignments bool
+}
+
+// wrappers are commands that run another command
+var wrappers = map[string]wrapper{
+	"sudo": {
+		valueFlags: []string{"-u", "-g", "-h", "-p", "-C", "-D", "-r", "-t", "-T", "-U", "--user", "--group", "--host", "--prompt", "--close-from", "--chdir", "--role", "--type", "--command-timeout", "--other-user"},
+		stopFlags:  []string{"-l", "-v", "-k", "-K", "-e", "--list", "--validate", "--reset-timestamp", "--remove-timestamp", "--edit"},
+	},
+	"doas": {
+		valueFlags: []string{"-u", "-C"},
+	},
+	"env": {
+		valueFlags:  []string{"-u", "-C", "-S", "--unset", "--chdir", "--split-string"},
+		assignments: true,
+	},
+	"time": {
+		valueFlags: []string{"-f", "-o", "--format", "--output"},
+	},
+	"nice": {
+		valueFlags: []string{"-n", "--adjustment"},
+	},
+	"nohup": {},
+	"exec": {
+		valueFlags: []string{"-a"},
+	},
+	"command": {
+		stopFlags: []string{"-v", "-V"},
+	},
+	"xargs": {
+		valueFlags: []string{"-I", "-n", "-P", "-d", "-L", "-s", "-E", "-a", "--max-args", "--max-procs", "--delimiter", "--max-lines", "--max-chars", "--eof", "--arg-file", "--process-slot-var"},
+	},
+	"watch": {
+		valueFlags: []string{"-n", "-q", "--interval", "--equexit"},
+	},
+}
+
+// unwrap strips wrapper commands and their options from the start of a
+// command, and returns the words of the effective command together with the
+// names of the wrappers. If a wrapper doesn't run a command, like sudo -i, it
+// is returned as the effective command.
+func unwrap(words []string) ([]string, []string) {
+	var wrapped []string
+
+loopWrappers:
+	for len(words) > 0 {
+		name := path.Base(words[0])
+		w, ok := wrappers[name]
+		if !ok {
+			break
+		}
+
+		i := 1
+	loopOptions:
+		for i < len(words) {
+			word := words[i]
+			switch {
+			case word == "--":
+				i++
+				break loopOptions
+			case contains(w.stopFlags, word):
+				break loopWrappers
+			case contains(w.valueFlags, word):
+				i += 2
+			case strings.HasPrefix(word, "-") && word != "-":
+				i++
+			case w.assignments && strings.Contains(word, "=") && isName(word[:strings.IndexByte(word, '=')]):
+				i++
+			default:
+				break loopOptions
+			}
+		}
+
+		if i >= len(words) {
+			break
+		}
+
+		wrapped = append(wrapped, name)
+		words = words[i:]
+	}
+
+	return words, wrapped
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"sort"
 	"strings"
 	"time"
@@ -13,9 +15,10 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	achievements "github.com/sturdy-dev/marblezero/achievements"
-	"github.com/sturdy-dev/marblezero/cats"
 	"github.com/sturdy-dev/marblezero/ingest"
+	"github.com/sturdy-dev/marblezero/pet"
 	"github.com/sturdy-dev/marblezero/shells"
+	"github.com/sturdy-dev/marblezero/sprites"
 	"github.com/sturdy-dev/marblezero/state"
 )
 
@@ -37,8 +40,6 @@ var (
 			Width(31).
 			Render
 
-	listItem = lipgloss.NewStyle().PaddingLeft(2).Render
-
 	checkMark = lipgloss.NewStyle().SetString("✓").
 			Foreground(special).
 			PaddingRight(1).
@@ -50,7 +51,7 @@ var (
 			Strikethrough(true).
 			Foreground(lipgloss.AdaptiveColor{Light: "#969B86", Dark: "#696969"}).
 			Background(orange).
-			Width(29).
+			Width(28).
 			Render(s)
 	}
 
@@ -67,11 +68,45 @@ var (
 
 var (
 	flagPreexec        = flag.String("import-single", "", "Import a single execution. To be used with shell pre/post-exec hooks")
+	flagPostexec       = flag.String("import-complete", "", "Import a finished execution. To be used with shell post-exec hooks")
+	flagExpanded       = flag.String("expanded", "", "Alias expanded form of the execution imported with --import-complete")
+	flagExitCode       = flag.Int("exit-code", 0, "Exit code of the execution imported with --import-complete")
+	flagDuration       = flag.Duration("duration", 0, "Wall-clock duration of the execution imported with --import-complete")
+	flagCwd            = flag.String("cwd", "", "Working directory of the execution imported with --import-complete")
 	flagFish           = flag.Bool("fish", false, "Print shell integration for the fish shell")
 	flagZsh            = flag.Bool("zsh", false, "Print shell integration for the zsh shell")
+	flagBash           = flag.Bool("bash", false, "Print shell integration for the bash shell")
+	flagImportHistory  = flag.String("import-history", "", "Import an existing shell history file, one of: zsh, bash, fish, atuin")
+	flagHistoryFile    = flag.String("history-file", "", "Path of the history file to import with --import-history, defaults to the shells default location")
+	flagCompact        = flag.Bool("compact", false, "Compact the history now, instead of when it has grown large")
+	flagCheck          = flag.Bool("check-achievements", false, "Check the achievements defined in the storage path for errors")
+	flagInstallPack    = flag.String("install-pack", "", "Install an achievement pack from a directory or git url")
+	flagUpgradePack    = flag.String("upgrade-pack", "", "Upgrade an installed achievement pack, by name")
+	flagRemovePack     = flag.String("remove-pack", "", "Remove an installed achievement pack, by name")
+	flagListPacks      = flag.Bool("list-packs", false, "List installed achievement packs")
 	flagDebugColorMode = flag.Bool("debug-colors", false, "Debug layout")
 )
 
+const (
+	// compactKeep is how much of the recent history is kept in the wal when it
+	// is compacted
+	compactKeep = 30 * 24 * time.Hour
+
+	// compactThreshold is how many lines can be appended to the wal after it
+	// was compacted, before it's compacted again on launch
+	compactThreshold = 10000
+
+	// activityStreakID is the achievement that tracks the streak shown on the
+	// home screen
+	activityStreakID = "daily-7"
+
+	// levelUpFrames is how long the level up animation is shown
+	levelUpFrames = 8
+
+	// excitedFrames is how long the cat is excited about new achievements
+	excitedFrames = 6
+)
+
 func main() {
 	flag.Parse()
 
@@ -81,6 +116,9 @@ func main() {
 	} else if *flagZsh {
 		fmt.Println(shells.Zsh)
 		return
+	} else if *flagBash {
+		fmt.Println(shells.Bash)
+		return
 	}
 
 	storagePath, err := state.NewStoragePath()
@@ -96,24 +134,211 @@ func main() {
 	}
 
 	if *flagPreexec != "" {
-		if err := ingest.Single(storagePath, *flagPreexec); err != nil {
+		if err := ingest.Single(storagePath, config, *flagPreexec); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flagPostexec != "" {
+		exec := ingest.Execution{
+			Cmd:      *flagPostexec,
+			Expanded: *flagExpanded,
+			ExitCode: *flagExitCode,
+			Duration: *flagDuration,
+			Cwd:      *flagCwd,
+		}
+		if err := ingest.Complete(storagePath, config, exec); err != nil {
 			log.Println(err)
 			os.Exit(1)
 		}
 		return
 	}
 
-	events, err := achievements.ParseHistory(storagePath)
+	if *flagImportHistory != "" {
+		if err := importHistory(storagePath, config, ingest.HistoryFormat(*flagImportHistory), *flagHistoryFile); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flagInstallPack != "" || *flagUpgradePack != "" || *flagRemovePack != "" || *flagListPacks {
+		if err := managePacks(storagePath); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *flagCheck {
+		if err := checkAchievements(storagePath); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	p, err := pet.Load(storagePath, time.Now)
 	if err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 
+	engine, err := loadEngine(storagePath, config, p)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	pack, err := sprites.Open(storagePath, config.Sprites)
+	if err != nil {
+		// fall back to the built-in cat
+		log.Println(err)
+		if pack, err = sprites.Open(storagePath, sprites.Builtin); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	// Graphical app
-	output(config, events)
+	output(config, engine, p, pack)
+}
+
+// loadEngine processes new events, feeding them to the pet, and compacts the
+// wal if it has grown large
+func loadEngine(storagePath state.StoragePath, config *state.Config, p *pet.Pet) (*achievements.Engine, error) {
+	calendar, err := achievements.NewCalendar(config.Timezone, config.DayRollover)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	all, err := achievements.Load(storagePath)
+	var defErrs achievements.DefinitionErrors
+	if errors.As(err, &defErrs) {
+		// the valid achievements are still used
+		log.Printf("invalid achievements:\n%v", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	engine, err := achievements.NewEngine(storagePath, all)
+	if err != nil {
+		return nil, err
+	}
+	engine.SetCalendar(calendar)
+	challenges, err := engine.WeeklyChallenges(calendar, time.Now(), config.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := engine.TrackQuests(append(achievements.DailyQuests(calendar, time.Now(), config.Name), challenges...)); err != nil {
+		return nil, err
+	}
+
+	engine.Subscribe(p.Feed)
+
+	err = engine.Update()
+	if err == nil && (*flagCompact || engine.Appended() >= compactThreshold) {
+		_, err = engine.Compact(time.Now().Add(-compactKeep))
+	}
+
+	var corrupt *achievements.CorruptError
+	if errors.As(err, &corrupt) {
+		// keep going with what could be read
+		log.Println(err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := p.Save(); err != nil {
+		return nil, err
+	}
+
+	return engine, nil
+}
+
+func checkAchievements(storagePath state.StoragePath) error {
+	all, err := achievements.Load(storagePath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d achievements, of which %d are defined in %s\n", len(all), len(all)-len(achievements.Achievements), path.Join(string(storagePath), "achievements"))
+	return nil
+}
+
+func managePacks(storagePath state.StoragePath) error {
+	switch {
+	case *flagInstallPack != "":
+		pack, err := achievements.InstallPack(storagePath, *flagInstallPack)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s %s by %s, with %d achievements\n", pack.Name, pack.Version, pack.Author, len(pack.Achievements))
+
+	case *flagUpgradePack != "":
+		installed, upgraded, err := achievements.UpgradePack(storagePath, *flagUpgradePack)
+		if err != nil {
+			return err
+		}
+		if installed == nil {
+			fmt.Printf("Installed %s %s again, as it could not be read\n", upgraded.Name, upgraded.Version)
+		} else if installed == upgraded {
+			fmt.Printf("%s %s is up to date\n", installed.Name, installed.Version)
+		} else {
+			fmt.Printf("Upgraded %s from %s to %s\n", upgraded.Name, installed.Version, upgraded.Version)
+		}
+
+	case *flagRemovePack != "":
+		if err := achievements.RemovePack(storagePath, *flagRemovePack); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", *flagRemovePack)
+
+	case *flagListPacks:
+		packs, err := achievements.InstalledPacks(storagePath)
+		var defErrs achievements.DefinitionErrors
+		if err != nil && !errors.As(err, &defErrs) {
+			return err
+		}
+		if len(packs) == 0 {
+			fmt.Println("No packs installed")
+		}
+		for _, pack := range packs {
+			fmt.Printf("%s %s by %s, %d achievements from %s\n", pack.Name, pack.Version, pack.Author, len(pack.Achievements), pack.Origin)
+		}
+		return err
+	}
+	return nil
+}
+
+func importHistory(storagePath state.StoragePath, config *state.Config, format ingest.HistoryFormat, historyPath string) error {
+	if historyPath == "" {
+		var err error
+		if historyPath, err = ingest.DefaultHistoryPath(format); err != nil {
+			return err
+		}
+	}
+
+	result, err := ingest.History(storagePath, config, format, historyPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Imported %d commands from %s\n", result.Imported, historyPath)
+	if result.Duplicates > 0 {
+		fmt.Printf("Skipped %d commands that were already imported\n", result.Duplicates)
+	}
+	if result.NoTimestamps > 0 {
+		fmt.Printf("Skipped %d commands without timestamps\n", result.NoTimestamps)
+	}
+	if result.Compacted > 0 {
+		fmt.Printf("Skipped %d commands older than the compacted history\n", result.Compacted)
+	}
+	return nil
 }
 
-func output(config *state.Config, events []achievements.HistoryEvent) {
+func output(config *state.Config, engine *achievements.Engine, p *pet.Pet, pack *sprites.Pack) {
 
 	// Set debug colors
 	if *flagDebugColorMode {
@@ -121,8 +346,8 @@ func output(config *state.Config, events []achievements.HistoryEvent) {
 		deviceRightStyle.Background(lipgloss.Color("#b91c1c"))
 	}
 
-	p := tea.NewProgram(NewModel(config, events))
-	if _, err := p.Run(); err != nil {
+	program := tea.NewProgram(NewModel(config, engine, p, pack))
+	if _, err := program.Run(); err != nil {
 		fmt.Printf("Alas, there's been an error: %v", err)
 		os.Exit(1)
 	}
@@ -135,6 +360,7 @@ const (
 	SetupNameScreen
 	ListAllAchievementsScreen
 	HelpScreen
+	QuestsScreen
 )
 
 type model struct {
@@ -143,13 +369,22 @@ type model struct {
 	textInput textinput.Model
 	config    *state.Config
 
-	events                []achievements.HistoryEvent
+	engine                *achievements.Engine
+	pet                   *pet.Pet
+	animator              *pet.Animator
+	sprites               *sprites.Pack
 	completedAchievements []achievements.Achievement
+	streak                achievements.Streak
+	xp                    int
+	level                 int
+
+	// the level up animation is shown until this frame
+	levelUpUntil int
 
 	rightScreenModel tea.Model
 }
 
-func NewModel(config *state.Config, events []achievements.HistoryEvent) *model {
+func NewModel(config *state.Config, engine *achievements.Engine, p *pet.Pet, pack *sprites.Pack) *model {
 	ti := textinput.New()
 	ti.Placeholder = "Marble"
 	ti.Focus()
@@ -167,26 +402,76 @@ func NewModel(config *state.Config, events []achievements.HistoryEvent) *model {
 	}
 
 	// Calculate awarded achievements
-	var completedAchievements []achievements.Achievement
-	for _, a := range achievements.Achievements {
-		if ok, at := a.Func(events); ok {
-			a.AwardedAt = *at
-			completedAchievements = append(completedAchievements, a)
-		}
-	}
+	completedAchievements := engine.Awarded()
 	sort.Slice(completedAchievements, func(a, b int) bool {
 		return completedAchievements[a].AwardedAt.After(completedAchievements[b].AwardedAt)
 	})
 
+	xp := engine.XP()
+	level := achievements.Level(xp)
+
+	// celebrate levels and achievements gained since the last time, but not
+	// what was reached before they were tracked
+	animator := pet.NewAnimator(p, engine.Calendar())
+	var latest time.Time
+	if len(completedAchievements) > 0 {
+		latest = completedAchievements[0].AwardedAt
+	}
+	if !p.LatestAchievement.IsZero() && latest.After(p.LatestAchievement) {
+		animator.Excite(excitedFrames)
+	}
+	levelUpUntil := 0
+	if p.Level != 0 && level > p.Level {
+		levelUpUntil = levelUpFrames
+		animator.Excite(levelUpFrames)
+	}
+	if level != p.Level || !latest.Equal(p.LatestAchievement) {
+		p.Level = level
+		p.LatestAchievement = latest
+		if err := p.Save(); err != nil {
+			log.Println(err)
+		}
+	}
+
 	return &model{
 		screen:                screen,
 		config:                config,
 		textInput:             ti,
-		events:                events,
+		engine:                engine,
+		pet:                   p,
+		animator:              animator,
+		sprites:               pack,
 		completedAchievements: completedAchievements,
+		streak:                activityStreak(engine, time.Now()),
+		xp:                    xp,
+		level:                 level,
+		levelUpUntil:          levelUpUntil,
 	}
 }
 
+// activityStreak is how many days in a row the terminal has been used, as
+// tracked by the achievement for it
+func activityStreak(engine *achievements.Engine, now time.Time) achievements.Streak {
+	for _, a := range engine.Achievements() {
+		if a.Key() == activityStreakID {
+			streak, _ := engine.Streak(a, now)
+			return streak
+		}
+	}
+	return achievements.Streak{}
+}
+
+// needs shows the stats of the pet as small bars
+func needs(s pet.Stats) string {
+	bar := func(v float64) string {
+		const width = 4
+		filled := int(v*width/100 + 0.5)
+		return lipgloss.NewStyle().Foreground(special).Render(strings.Repeat("█", filled)) +
+			lipgloss.NewStyle().Foreground(subtle).Render(strings.Repeat("░", width-filled))
+	}
+	return fmt.Sprintf("Food %s Rest %s Fun %s", bar(s.Hunger), bar(s.Energy), bar(s.Happiness))
+}
+
 func (m model) Init() tea.Cmd {
 	return tea.Batch(textinput.Blink, m.characterAnimation())
 }
@@ -196,6 +481,14 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		// screens on the right handle their own keys, and go back home with
+		// goToHomeCmd
+		if m.rightScreenModel != nil && msg.String() != "ctrl+c" {
+			var cmd tea.Cmd
+			m.rightScreenModel, cmd = m.rightScreenModel.Update(msg)
+			return m, cmd
+		}
+
 		switch msg.String() {
 		case "enter":
 			if m.screen == SetupNameScreen {
@@ -209,10 +502,6 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.screen = HomeScreen
 					m.frame = 0 // reset counter
 				}
-			} else if m.screen == ListAllAchievementsScreen {
-				m.screen = HomeScreen // go back
-			} else if m.screen == HelpScreen {
-				m.screen = HomeScreen // go back
 			} else {
 				return m, tea.Quit
 			}
@@ -227,7 +516,21 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "a":
 			if m.screen == HomeScreen {
 				m.screen = ListAllAchievementsScreen
-				m.rightScreenModel = NewShowAllAchievementsModel(m.events)
+				m.rightScreenModel = NewShowAllAchievementsModel(m.engine)
+			}
+
+		// Today's quests
+		case "d":
+			if m.screen == HomeScreen {
+				m.screen = QuestsScreen
+				m.rightScreenModel = NewQuestsModel(m.engine, false)
+			}
+
+		// This week's challenges
+		case "w":
+			if m.screen == HomeScreen {
+				m.screen = QuestsScreen
+				m.rightScreenModel = NewQuestsModel(m.engine, true)
 			}
 
 		// show help
@@ -237,14 +540,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.rightScreenModel = NewHelpModel()
 			}
 
-		// Quit program if on home, else go back
+		// Quit program if on home
 		case "q", "esc":
 			if m.screen == HomeScreen {
 				return m, tea.Quit
-			} else if m.screen == ListAllAchievementsScreen {
-				m.screen = HomeScreen // go back
-			} else if m.screen == HelpScreen {
-				m.screen = HomeScreen // go back
 			}
 		// Quit program
 		case "ctrl+c":
@@ -253,6 +552,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case characterAnimationMsg:
 		m.frame++
+		m.animator.Step()
 		return m, m.characterAnimation()
 
 	case goToHomeMsg:
@@ -260,10 +560,6 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.rightScreenModel = nil
 	}
 
-	if m.rightScreenModel != nil {
-		m.rightScreenModel.Update(msg)
-	}
-
 	var cmd tea.Cmd
 	if preScreen == SetupNameScreen && m.screen == SetupNameScreen {
 		m.textInput, cmd = m.textInput.Update(msg)
@@ -275,50 +571,50 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m model) View() string {
 	doc := strings.Builder{}
 
-	var cat string
-
-	switch m.screen {
-	case SetupNameScreen:
-		cat = cats.CatCurious
-	default:
-		cats := []string{
-			cats.CatNormalStraight,
-			cats.CatNormalStraightRaisedTail,
-			cats.CatNormalStraight,
-			cats.CatNormalRight,
-			cats.CatNormalStraight,
-			cats.CatAmused,
-			cats.CatNormalStraight,
-			cats.CatNormalStraightFoldedLeftEar,
-			cats.CatNormalStraight,
-		}
-		cat = cats[m.frame%len(cats)]
-	}
-
-	level := len(m.completedAchievements)/3 + 1
-	xp := len(m.completedAchievements) * 13
+	// the frame is rendered without the size of the cat's side of the
+	// screen, which is added around it
+	cat := m.sprite().Render(catStyle.Copy().UnsetWidth().UnsetHeight())
 
 	var deviceRight string
 	switch m.screen {
 	case HomeScreen:
-		charStats := fmt.Sprintf("%s\nMood: Happy\nLevel: %d (%d XP)", m.config.Name, level, xp)
+		stats := m.pet.Now()
+		charStats := fmt.Sprintf("%s\nMood: %s\n%s\nLevel: %d (%d/%d XP)\nStreak: %d days (best %d)",
+			m.config.Name, stats.Mood(), needs(stats), m.level, m.xp, achievements.LevelXP(m.level+1), m.streak.Current, m.streak.Longest)
 
 		latestAchievementHeader := inScreenStyle.Copy().
 			BorderStyle(lipgloss.NormalBorder()).
 			BorderBottom(true).
 			BorderForeground(yellow).
 			Foreground(yellow).
-			MarginTop(2).
+			MarginTop(1).
 			Width(29).
 			Render("Latest Achievements")
 
+		if m.frame < m.levelUpUntil {
+			// blink
+			color := yellow
+			if m.frame%2 == 1 {
+				color = lipgloss.Color("#FAFAFA")
+			}
+			latestAchievementHeader = inScreenStyle.Copy().
+				BorderStyle(lipgloss.NormalBorder()).
+				BorderBottom(true).
+				BorderForeground(color).
+				Foreground(color).
+				Bold(true).
+				MarginTop(1).
+				Width(29).
+				Render(fmt.Sprintf("★ Level up! Level %d ★", m.level))
+		}
+
 		a := m.completedAchievements[0]
 
 		achievementName := inScreenStyle.Copy().Bold(true).Render(a.Name)
 		// achievementXP := inScreenStyle.Copy().Render(fmt.Sprintf(" (%d XP)", 25))
 		achievementDescription := inScreenStyle.Copy().Foreground(subtle).Render(a.Description)
 
-		latestAchievement := inScreenStyle.Copy().Width(29).Render(fmt.Sprintf("%s\n%s\n(%d XP)", achievementName, achievementDescription, 12))
+		latestAchievement := inScreenStyle.Copy().Width(29).Render(fmt.Sprintf("%s\n%s\n(%s, %d XP)", achievementName, achievementDescription, a.Tier(), a.XP()))
 
 		deviceRight = deviceRightStyle.Copy().PaddingLeft(3).Render(
 			lipgloss.JoinVertical(lipgloss.Left, charStats, latestAchievementHeader, latestAchievement),
@@ -328,7 +624,7 @@ func (m model) View() string {
 		bubble := inScreenStyle.Copy().Padding(0).Height(0).Render(lipgloss.JoinHorizontal(lipgloss.Bottom, "<\n", speechBubble.Render("Meow! Meow!\nWhat's my name?")))
 		deviceRight = deviceRightStyle.PaddingLeft(3).Render(lipgloss.JoinVertical(lipgloss.Left, "\n\n", bubble, m.textInput.View()))
 
-	case ListAllAchievementsScreen, HelpScreen:
+	case ListAllAchievementsScreen, HelpScreen, QuestsScreen:
 		deviceRight = m.rightScreenModel.View()
 	}
 
@@ -358,8 +654,19 @@ func (m model) View() string {
 	return doc.String()
 }
 
+// sprite is the frame the cat is drawn with at the moment
+func (m model) sprite() sprites.Frame {
+	if m.screen == SetupNameScreen {
+		return m.sprites.Frame("curious", m.frame)
+	}
+	s, frame := m.animator.State()
+	return m.sprites.Frame(string(s), frame)
+}
+
+// characterAnimation moves on to the next frame once the current one has been
+// shown for its duration
 func (m model) characterAnimation() tea.Cmd {
-	return tea.Tick(time.Second/2, func(t time.Time) tea.Msg {
+	return tea.Tick(m.sprite().Duration, func(t time.Time) tea.Msg {
 		return characterAnimationMsg(t)
 	})
 }
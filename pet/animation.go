@@ -0,0 +1,94 @@
+package pet
+
+import (
+	"time"
+
+	"github.com/sturdy-dev/marblezero/achievements"
+)
+
+// State is what the pet is up to, each state has an animation of its own
+type State string
+
+const (
+	Idle     State = "idle"
+	Sleeping State = "sleeping"
+	Excited  State = "excited"
+	Sulking  State = "sulking"
+)
+
+const (
+	// sulkAfterFailures is how many commands in a row have to fail for the pet
+	// to sulk
+	sulkAfterFailures = 3
+
+	// sulkAfterIdle is how long the pet can go without commands before it
+	// sulks
+	sulkAfterIdle = 24 * time.Hour
+
+	// nightStart and nightEnd are the hours of the day the pet sleeps
+	// between, see achievements.Calendar.Hour
+	nightStart = 22
+	nightEnd   = 6
+)
+
+// Animator is the state machine that decides which animation the pet is
+// shown with, frame by frame. Excitement comes and goes, otherwise the state
+// follows the needs of the pet and the time of day: a pet that is upset
+// sulks, and one that is tired or up at night sleeps.
+type Animator struct {
+	pet      *Pet
+	calendar achievements.Calendar
+	state    State
+	frame    int // frames the state has been shown for
+	excited  int // frames of excitement left
+}
+
+// NewAnimator creates an animator for the pet, starting in the state that
+// suits it. The calendar decides when it's night.
+func NewAnimator(p *Pet, calendar achievements.Calendar) *Animator {
+	a := &Animator{pet: p, calendar: calendar}
+	a.state = a.next()
+	return a
+}
+
+// Excite makes the pet excited for the frames, like when an achievement was
+// awarded
+func (a *Animator) Excite(frames int) {
+	a.excited = frames
+	a.enter(Excited)
+}
+
+// Step moves on to the next frame
+func (a *Animator) Step() {
+	if a.excited > 0 {
+		a.excited--
+	}
+	a.frame++
+	a.enter(a.next())
+}
+
+// State returns the current state, and the frame within it. The frame starts
+// from 0 every time a state is entered, so that its animation starts over.
+func (a *Animator) State() (State, int) {
+	return a.state, a.frame
+}
+
+func (a *Animator) enter(s State) {
+	if s != a.state {
+		a.state, a.frame = s, 0
+	}
+}
+
+// next is the state the pet should be in
+func (a *Animator) next() State {
+	if a.excited > 0 {
+		return Excited
+	}
+	if a.pet.Failures >= sulkAfterFailures || a.pet.SinceFed() >= sulkAfterIdle {
+		return Sulking
+	}
+	if hour := a.calendar.Hour(a.pet.clock()); hour >= nightStart || hour < nightEnd || a.pet.Mood() == Exhausted {
+		return Sleeping
+	}
+	return Idle
+}
@@ -0,0 +1,104 @@
+package pet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/sturdy-dev/marblezero/achievements"
+)
+
+func TestAnimator(t *testing.T) {
+	exit := func(code int) *int {
+		return &code
+	}
+	state := func(a *Animator) State {
+		s, _ := a.State()
+		return s
+	}
+
+	t.Run("day and night", func(t *testing.T) {
+		p, clock := newPet(t)
+		a := NewAnimator(p, achievements.Calendar{})
+		assert.Equal(t, Idle, state(a))
+
+		// fed at the end of the day, so that it isn't idle for long
+		clock.now = time.Date(2022, 11, 1, 22, 30, 0, 0, time.Local)
+		p.Feed(achievements.HistoryEvent{Cmd: "ls", At: clock.Now()})
+		a.Step()
+		assert.Equal(t, Sleeping, state(a))
+
+		clock.Advance(8 * time.Hour)
+		a.Step()
+		assert.Equal(t, Idle, state(a))
+	})
+
+	t.Run("calendar", func(t *testing.T) {
+		p, clock := newPet(t)
+		clock.now = time.Date(2022, 11, 1, 14, 30, 0, 0, time.UTC)
+		p.Feed(achievements.HistoryEvent{Cmd: "ls", At: clock.Now()})
+
+		// 23:30 in the time zone of the calendar
+		tokyo := achievements.Calendar{Location: time.FixedZone("JST", 9*60*60)}
+		assert.Equal(t, Sleeping, state(NewAnimator(p, tokyo)))
+
+		// still the evening, as the day starts at 4
+		tokyo.Rollover = 4
+		assert.Equal(t, Idle, state(NewAnimator(p, tokyo)))
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		p, _ := newPet(t)
+		p.Stats.Energy = 10
+		assert.Equal(t, Sleeping, state(NewAnimator(p, achievements.Calendar{})))
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		p, clock := newPet(t)
+		a := NewAnimator(p, achievements.Calendar{})
+		for i := 0; i < sulkAfterFailures; i++ {
+			assert.Equal(t, Idle, state(a))
+			p.Feed(achievements.HistoryEvent{Cmd: "make", At: clock.Now(), ExitCode: exit(2)})
+			a.Step()
+		}
+		assert.Equal(t, Sulking, state(a))
+
+		p.Feed(achievements.HistoryEvent{Cmd: "make", At: clock.Now(), ExitCode: exit(0)})
+		a.Step()
+		assert.Equal(t, Idle, state(a))
+	})
+
+	t.Run("idle", func(t *testing.T) {
+		p, clock := newPet(t)
+		a := NewAnimator(p, achievements.Calendar{})
+		clock.Advance(sulkAfterIdle)
+		a.Step()
+		assert.Equal(t, Sulking, state(a))
+	})
+
+	t.Run("excited", func(t *testing.T) {
+		p, _ := newPet(t)
+		a := NewAnimator(p, achievements.Calendar{})
+		a.Step()
+		a.Step()
+		_, frame := a.State()
+		assert.Equal(t, 2, frame)
+
+		// the animation starts over
+		a.Excite(3)
+		s, frame := a.State()
+		assert.Equal(t, Excited, s)
+		assert.Equal(t, 0, frame)
+
+		a.Step()
+		a.Step()
+		s, frame = a.State()
+		assert.Equal(t, Excited, s)
+		assert.Equal(t, 2, frame)
+
+		a.Step()
+		s, frame = a.State()
+		assert.Equal(t, Idle, s)
+		assert.Equal(t, 0, frame)
+	})
+}
@@ -0,0 +1,188 @@
+package pet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"time"
+
+	"github.com/sturdy-dev/marblezero/achievements"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+// Clock returns the current time, it's time.Now outside of tests
+type Clock func() time.Time
+
+// Stats are the needs of the pet, from 0 to 100 where higher is better: a
+// full belly, a rested pet and a happy one
+type Stats struct {
+	Hunger    float64 `json:"hunger"`
+	Energy    float64 `json:"energy"`
+	Happiness float64 `json:"happiness"`
+}
+
+const maxStat = 100
+
+// decay is how much each stat goes down in an hour
+var decay = Stats{
+	Hunger:    4, // a full belly lasts a day
+	Energy:    2,
+	Happiness: 3,
+}
+
+// Command activity replenishes the stats. Any command feeds the pet, but only
+// commands that succeed give it energy, and failing ones upset it.
+var (
+	fed       = Stats{Hunger: 3}
+	succeeded = Stats{Energy: 1, Happiness: 2}
+	failed    = Stats{Happiness: -2}
+)
+
+func (s Stats) add(o Stats, scale float64) Stats {
+	return Stats{
+		Hunger:    clamp(s.Hunger + o.Hunger*scale),
+		Energy:    clamp(s.Energy + o.Energy*scale),
+		Happiness: clamp(s.Happiness + o.Happiness*scale),
+	}
+}
+
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(maxStat, v))
+}
+
+// Mood sums up how the pet is doing
+type Mood string
+
+const (
+	Happy     Mood = "Happy"
+	Content   Mood = "Content"
+	Hungry    Mood = "Hungry"
+	Starving  Mood = "Starving"
+	Tired     Mood = "Tired"
+	Exhausted Mood = "Exhausted"
+	Sad       Mood = "Sad"
+)
+
+// moodThresholds are checked in order, the first need below its threshold
+// decides the mood
+var moodThresholds = []struct {
+	mood      Mood
+	threshold float64
+	stat      func(Stats) float64
+}{
+	{Starving, 15, func(s Stats) float64 { return s.Hunger }},
+	{Exhausted, 15, func(s Stats) float64 { return s.Energy }},
+	{Hungry, 40, func(s Stats) float64 { return s.Hunger }},
+	{Tired, 40, func(s Stats) float64 { return s.Energy }},
+	{Sad, 40, func(s Stats) float64 { return s.Happiness }},
+	{Content, 70, func(s Stats) float64 { return s.Happiness }},
+}
+
+// Mood returns the mood of a pet with the stats
+func (s Stats) Mood() Mood {
+	for _, t := range moodThresholds {
+		if t.stat(s) < t.threshold {
+			return t.mood
+		}
+	}
+	return Happy
+}
+
+// Pet simulates the needs of the pet. It gets hungry, tired and sad as time
+// goes by, and is fed, rested and cheered up by running commands. The state
+// is saved in the storage path.
+type Pet struct {
+	Stats     Stats     `json:"stats"`
+	UpdatedAt time.Time `json:"updated_at"`         // when Stats were last decayed
+	Failures  int       `json:"failures,omitempty"` // commands that failed in a row
+
+	// Level is the level the pet was at when it was last shown, and
+	// LatestAchievement when the latest achievement that was shown was
+	// awarded, for the pet to get excited about new ones
+	Level             int       `json:"level,omitempty"`
+	LatestAchievement time.Time `json:"latest_achievement"`
+
+	clock       Clock
+	storagePath state.StoragePath
+}
+
+func petPath(storagePath state.StoragePath) string {
+	return path.Join(string(storagePath), "pet.json")
+}
+
+// Load restores the pet from the storage path. A pet that hasn't been saved
+// before starts out with all needs met.
+func Load(storagePath state.StoragePath, clock Clock) (*Pet, error) {
+	p := &Pet{
+		Stats:       Stats{Hunger: maxStat, Energy: maxStat, Happiness: maxStat},
+		UpdatedAt:   clock(),
+		clock:       clock,
+		storagePath: storagePath,
+	}
+
+	contents, err := os.ReadFile(petPath(storagePath))
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read pet: %w", err)
+	}
+	if err := json.Unmarshal(contents, p); err != nil {
+		return nil, fmt.Errorf("failed to parse pet: %w", err)
+	}
+	return p, nil
+}
+
+// Save persists the pet in the storage path
+func (p *Pet) Save() error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pet: %w", err)
+	}
+	if err := os.WriteFile(petPath(p.storagePath), data, 0660); err != nil {
+		return fmt.Errorf("failed to save pet: %w", err)
+	}
+	return nil
+}
+
+// at returns the stats decayed until the time
+func (p *Pet) at(t time.Time) Stats {
+	if !t.After(p.UpdatedAt) {
+		return p.Stats
+	}
+	return p.Stats.add(decay, -t.Sub(p.UpdatedAt).Hours())
+}
+
+// Now returns the stats as of now
+func (p *Pet) Now() Stats {
+	return p.at(p.clock())
+}
+
+// SinceFed is how long it's been since the pet was last fed
+func (p *Pet) SinceFed() time.Duration {
+	return p.clock().Sub(p.UpdatedAt)
+}
+
+// Mood returns the mood of the pet as of now
+func (p *Pet) Mood() Mood {
+	return p.Now().Mood()
+}
+
+// Feed replenishes the stats with a command that was run. Commands from
+// before the stats were last updated, like imported history, are ignored.
+func (p *Pet) Feed(event achievements.HistoryEvent) {
+	if event.At.Before(p.UpdatedAt) {
+		return
+	}
+	stats := p.at(event.At).add(fed, 1)
+	if event.ExitCode != nil && *event.ExitCode != 0 {
+		stats = stats.add(failed, 1)
+		p.Failures++
+	} else {
+		stats = stats.add(succeeded, 1)
+		p.Failures = 0
+	}
+	p.Stats, p.UpdatedAt = stats, event.At
+}
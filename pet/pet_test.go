@@ -0,0 +1,124 @@
+package pet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/achievements"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newPet(t *testing.T) (*Pet, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)}
+	p, err := Load(state.StoragePath(t.TempDir()), clock.Now)
+	require.NoError(t, err)
+	return p, clock
+}
+
+func TestDecay(t *testing.T) {
+	p, clock := newPet(t)
+	assert.Equal(t, Stats{Hunger: 100, Energy: 100, Happiness: 100}, p.Now())
+	assert.Equal(t, Happy, p.Mood())
+
+	clock.Advance(10 * time.Hour)
+	assert.Equal(t, Stats{Hunger: 60, Energy: 80, Happiness: 70}, p.Now())
+	assert.Equal(t, Happy, p.Mood())
+
+	clock.Advance(2 * time.Hour)
+	assert.Equal(t, Content, p.Mood())
+
+	clock.Advance(8 * time.Hour)
+	assert.Equal(t, Stats{Hunger: 20, Energy: 60, Happiness: 40}, p.Now())
+	assert.Equal(t, Hungry, p.Mood())
+
+	// stats never go below zero
+	clock.Advance(100 * time.Hour)
+	assert.Equal(t, Stats{}, p.Now())
+	assert.Equal(t, Starving, p.Mood())
+}
+
+func TestFeed(t *testing.T) {
+	p, clock := newPet(t)
+	start := clock.Now()
+	exit := func(code int) *int {
+		return &code
+	}
+
+	clock.Advance(10 * time.Hour)
+	p.Feed(achievements.HistoryEvent{Cmd: "ls", At: start.Add(5 * time.Hour), ExitCode: exit(0)})
+	assert.Equal(t, Stats{Hunger: 83, Energy: 91, Happiness: 87}, p.at(start.Add(5*time.Hour)))
+	assert.Equal(t, Stats{Hunger: 63, Energy: 81, Happiness: 72}, p.Now())
+
+	// failing commands still feed the pet, but upset it
+	p.Feed(achievements.HistoryEvent{Cmd: "make", At: start.Add(10 * time.Hour), ExitCode: exit(2)})
+	assert.Equal(t, Stats{Hunger: 66, Energy: 81, Happiness: 70}, p.Now())
+
+	// commands without an exit code, like imports, count as succeeded
+	p.Feed(achievements.HistoryEvent{Cmd: "ls", At: start.Add(10 * time.Hour)})
+	assert.Equal(t, Stats{Hunger: 69, Energy: 82, Happiness: 72}, p.Now())
+
+	// history from before the pet was last updated doesn't count
+	p.Feed(achievements.HistoryEvent{Cmd: "ls", At: start})
+	assert.Equal(t, Stats{Hunger: 69, Energy: 82, Happiness: 72}, p.Now())
+
+	// and stats never go above the max
+	for i := 0; i < 100; i++ {
+		p.Feed(achievements.HistoryEvent{Cmd: "ls", At: start.Add(10 * time.Hour)})
+	}
+	assert.Equal(t, Stats{Hunger: 100, Energy: 100, Happiness: 100}, p.Now())
+}
+
+func TestMood(t *testing.T) {
+	cases := []struct {
+		stats Stats
+		mood  Mood
+	}{
+		{Stats{Hunger: 100, Energy: 100, Happiness: 100}, Happy},
+		{Stats{Hunger: 100, Energy: 100, Happiness: 50}, Content},
+		{Stats{Hunger: 100, Energy: 100, Happiness: 10}, Sad},
+		{Stats{Hunger: 100, Energy: 30, Happiness: 10}, Tired},
+		{Stats{Hunger: 30, Energy: 30, Happiness: 10}, Hungry},
+		{Stats{Hunger: 30, Energy: 10, Happiness: 10}, Exhausted},
+		{Stats{Hunger: 10, Energy: 10, Happiness: 10}, Starving},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.mood, tc.stats.Mood(), "%+v", tc.stats)
+	}
+}
+
+func TestSave(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	clock := &fakeClock{now: time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)}
+
+	p, err := Load(storagePath, clock.Now)
+	require.NoError(t, err)
+	clock.Advance(5 * time.Hour)
+	p.Feed(achievements.HistoryEvent{Cmd: "ls", At: clock.Now()})
+	p.Level = 3
+	p.LatestAchievement = clock.Now()
+	require.NoError(t, p.Save())
+
+	// the pet keeps decaying while it's not running
+	clock.Advance(5 * time.Hour)
+	restored, err := Load(storagePath, clock.Now)
+	require.NoError(t, err)
+	assert.Equal(t, p.Now(), restored.Now())
+	assert.Equal(t, Stats{Hunger: 63, Energy: 81, Happiness: 72}, restored.Now())
+	assert.Equal(t, 3, restored.Level)
+	assert.True(t, p.LatestAchievement.Equal(restored.LatestAchievement))
+}
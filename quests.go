@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sturdy-dev/marblezero/achievements"
+)
+
+type questsModel struct {
+	engine *achievements.Engine
+	weekly bool // show the weekly challenges instead of the daily quests
+}
+
+func NewQuestsModel(engine *achievements.Engine, weekly bool) tea.Model {
+	return &questsModel{engine: engine, weekly: weekly}
+}
+
+func (m *questsModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *questsModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "tab", "shift+tab", "left", "right", "n", "p":
+			m.weekly = !m.weekly
+		case "q", "esc", "enter":
+			return m, goToHomeCmd
+		}
+	}
+	return m, nil
+}
+
+func (m *questsModel) View() string {
+	period, title, footer := achievements.Daily, "Daily Quests", "New quests every day"
+	if m.weekly {
+		period, title, footer = achievements.Weekly, "Weekly Challenges", m.badges()
+	}
+
+	lines := []string{
+		listHeader(title),
+	}
+
+	for _, q := range m.engine.Quests() {
+		if q.Period != period {
+			continue
+		}
+		progress := m.engine.QuestProgress(q)
+		if progress.Awarded() {
+			lines = append(lines, listDone(truncate(q.Description, 28)), "")
+			continue
+		}
+		lines = append(lines,
+			" "+q.Description,
+			fmt.Sprintf(" %s %d/%d", progressBar(progress, 16), progress.Current(), progress.Target),
+		)
+	}
+
+	lines = append(lines, lipgloss.NewStyle().Foreground(subtle).Render(footer), "",
+		lipgloss.NewStyle().Foreground(subtle).Render("(tab to switch, enter to go back)"))
+
+	return deviceRightStyle.Copy().PaddingLeft(1).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
+
+// badges sums up the badges for completed challenges
+func (m *questsModel) badges() string {
+	badges := m.engine.Badges()
+	if len(badges) == 0 {
+		return "Complete one for a badge"
+	}
+	latest := badges[len(badges)-1].Name
+	if len(badges) == 1 {
+		return "Badge: " + latest
+	}
+	return truncate(fmt.Sprintf("%d badges, latest %s", len(badges), latest), 31)
+}
@@ -0,0 +1,164 @@
+package shells_test
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/achievements"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+// runBash runs the lines in a non-interactive bash with the integration
+// loaded, and returns what was recorded in history_wal.
+//
+// Non-interactive shells never show a prompt, so each line is preceded by
+// running PROMPT_COMMAND manually, just like an interactive shell would.
+func runBash(t *testing.T, histcontrol string, lines ...string) []achievements.HistoryEvent {
+	t.Helper()
+	events, _ := runBashWith(t, histcontrol, "", lines...)
+	return events
+}
+
+// runBashWith is runBash with setup that runs before the integration is
+// loaded, it also returns the home directory
+func runBashWith(t *testing.T, histcontrol, setup string, lines ...string) ([]achievements.HistoryEvent, string) {
+	t.Helper()
+
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	dir := t.TempDir()
+	bin := path.Join(dir, "bin")
+	home := path.Join(dir, "home")
+	require.NoError(t, os.MkdirAll(home, 0777))
+
+	build := exec.Command("go", "build", "-o", path.Join(bin, "marblezero"), "github.com/sturdy-dev/marblezero")
+	out, err := build.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	var script strings.Builder
+	script.WriteString("set -o history\n")
+	script.WriteString("PROMPT_COMMAND='true'\n")
+	script.WriteString(setup + "\n")
+	script.WriteString("eval \"$(marblezero --bash)\"\n")
+	for _, line := range lines {
+		script.WriteString("eval \"$PROMPT_COMMAND\"\n")
+		script.WriteString(line + "\n")
+	}
+	script.WriteString("eval \"$PROMPT_COMMAND\"\n")
+
+	cmd := exec.Command("bash", "--norc", "--noprofile")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(script.String())
+	cmd.Env = []string{
+		"HOME=" + home,
+		"PATH=" + bin + ":" + os.Getenv("PATH"),
+		"HISTCONTROL=" + histcontrol,
+	}
+	out, err = cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	events, err := achievements.ParseHistory(state.StoragePath(path.Join(home, ".config", "marblezero")))
+	require.NoError(t, err)
+	return events, home
+}
+
+func TestBashOncePerCommand(t *testing.T) {
+	events := runBash(t, "",
+		"echo one | cat | cat",
+		"pwd",
+	)
+
+	// The pipeline is recorded once, as one event per stage, and
+	// PROMPT_COMMAND is not recorded at all
+	lines := achievements.Lines(events)
+	require.Len(t, lines, 2)
+	require.Len(t, lines[0], 3)
+	assert.Equal(t, "echo", lines[0][0].Cmd)
+	assert.Equal(t, "cat", lines[0][2].Cmd)
+	require.Len(t, lines[1], 1)
+	assert.Equal(t, "pwd", lines[1][0].Cmd)
+}
+
+func TestBashOutcome(t *testing.T) {
+	events := runBash(t, "",
+		"true",
+		"(exit 3)",
+		"sleep 0.2",
+	)
+
+	require.Len(t, events, 3)
+
+	require.NotNil(t, events[0].ExitCode)
+	assert.Equal(t, 0, *events[0].ExitCode)
+
+	require.NotNil(t, events[1].ExitCode)
+	assert.Equal(t, 3, *events[1].ExitCode)
+
+	assert.Equal(t, "sleep", events[2].Cmd)
+	assert.GreaterOrEqual(t, events[2].Duration.Milliseconds(), int64(200))
+	assert.NotEmpty(t, events[2].Cwd)
+}
+
+func TestBashHistControl(t *testing.T) {
+	events := runBash(t, "ignoreboth",
+		"git version",
+		"git version",
+		" echo hidden",
+		"pwd",
+	)
+
+	// Duplicates are still recorded, but commands starting with a space are not
+	var cmds []string
+	for _, e := range events {
+		cmds = append(cmds, e.Cmd)
+	}
+	assert.Equal(t, []string{"git", "git", "pwd"}, cmds)
+}
+
+func TestBashLeadingSpace(t *testing.T) {
+	events := runBash(t, "",
+		" echo secret",
+		"pwd",
+	)
+
+	// Without ignorespace the command is in the history, but marblezero
+	// still ignores it
+	var cmds []string
+	for _, e := range events {
+		cmds = append(cmds, e.Cmd)
+	}
+	assert.Equal(t, []string{"pwd"}, cmds)
+}
+
+func TestBashAliases(t *testing.T) {
+	events := runBash(t, "",
+		"shopt -s expand_aliases",
+		"alias g='git --no-pager'",
+		"g version",
+	)
+
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	assert.Equal(t, "git", last.Cmd)
+	assert.Equal(t, "version", last.SubCommand)
+	assert.Equal(t, "g", last.Typed)
+}
+
+func TestBashExistingDebugTrap(t *testing.T) {
+	events, home := runBashWith(t, "",
+		`trap '[[ $BASH_COMMAND == pwd ]] && touch "$HOME/it'\''s a trap"' DEBUG`,
+		"pwd",
+	)
+
+	// Both the command is recorded and the trap that was already set runs
+	require.Len(t, events, 1)
+	assert.Equal(t, "pwd", events[0].Cmd)
+	assert.FileExists(t, path.Join(home, "it's a trap"))
+}
@@ -0,0 +1,87 @@
+_marblezero_clock() {
+    # EPOCHREALTIME is only available in bash 5+, and uses the locale's decimal separator
+    _marblezero_now=${EPOCHREALTIME//[!0-9]/}
+    if [[ -z "$_marblezero_now" ]]; then
+        _marblezero_now=$((SECONDS * 1000000))
+    fi
+}
+
+_marblezero_preexec() {
+    # Only fire once per prompt: not for every stage of a pipeline, not for
+    # the commands run by PROMPT_COMMAND and not during tab completion
+    if [[ -z "$_marblezero_ready" || -n "$COMP_LINE" ]]; then
+        return
+    fi
+    unset _marblezero_ready
+
+    local line histnum cmd
+    line=$(HISTTIMEFORMAT= builtin history 1)
+    # Keep the command's own leading spaces, they hide it from marblezero too
+    if [[ "$line" =~ ^\ *([0-9]+)[*\ ]\ (.*)$ ]]; then
+        histnum=${BASH_REMATCH[1]}
+        cmd=${BASH_REMATCH[2]}
+    fi
+
+    if [[ -z "$histnum" ]]; then
+        # History is disabled, fall back to the first simple command
+        cmd=$BASH_COMMAND
+    elif [[ "$histnum" == "$_marblezero_histnum" ]]; then
+        # HISTCONTROL kept the command out of the history. Repeated commands
+        # (ignoredups) are still recorded, but commands that were hidden on
+        # purpose (ignorespace, HISTIGNORE) are not.
+        if [[ "$cmd" != "$BASH_COMMAND"* ]]; then
+            return
+        fi
+    fi
+
+    _marblezero_cmd=$cmd
+    _marblezero_expanded=
+
+    local first=${cmd%%[[:space:]]*}
+    if [[ -n "$first" && -n "${BASH_ALIASES[$first]}" ]]; then
+        _marblezero_expanded="${BASH_ALIASES[$first]}${cmd:${#first}}"
+    fi
+
+    _marblezero_clock
+    _marblezero_started=$_marblezero_now
+}
+
+_marblezero_precmd() {
+    local exit_code=$?
+
+    if [[ -n "$_marblezero_cmd" ]]; then
+        _marblezero_clock
+        local duration_ms=$(((_marblezero_now - _marblezero_started) / 1000))
+        marblezero --exit-code "$exit_code" --duration "${duration_ms}ms" --cwd "$PWD" --expanded "$_marblezero_expanded" --import-complete "$_marblezero_cmd"
+        unset _marblezero_cmd _marblezero_expanded
+    fi
+
+    local line
+    line=$(HISTTIMEFORMAT= builtin history 1)
+    if [[ "$line" =~ ^[[:space:]]*([0-9]+) ]]; then
+        _marblezero_histnum=${BASH_REMATCH[1]}
+    fi
+}
+
+if [[ -z "$_marblezero_installed" ]]; then
+    _marblezero_installed=1
+
+    # The exit code has to be read before any other PROMPT_COMMAND runs, and
+    # the next command may only be picked up after all of them have finished
+    if [[ "$(declare -p PROMPT_COMMAND 2>/dev/null)" == "declare -a"* ]]; then
+        PROMPT_COMMAND=("_marblezero_precmd" "${PROMPT_COMMAND[@]}" "_marblezero_ready=1")
+    else
+        PROMPT_COMMAND="_marblezero_precmd"$'\n'"${PROMPT_COMMAND:+$PROMPT_COMMAND$'\n'}_marblezero_ready=1"
+    fi
+
+    # Keep running a DEBUG trap that was already set, like the one of
+    # bash-preexec. trap -p prints it quoted, as: trap -- '...' DEBUG
+    eval "_marblezero_trap=($(trap -p DEBUG))"
+    _marblezero_debug_trap=${_marblezero_trap[2]}
+    unset _marblezero_trap
+    if [[ -n "$_marblezero_debug_trap" ]]; then
+        trap '_marblezero_preexec; eval "$_marblezero_debug_trap"' DEBUG
+    else
+        trap '_marblezero_preexec' DEBUG
+    fi
+fi
\ No newline at end of file
@@ -1,3 +1,17 @@
-function marblezero_preexec --on-event fish_preexec
-  marblezero --import-single "$argv"
+function marblezero_postexec --on-event fish_postexec
+  set -l exit_code $status
+  if test -z "$argv"
+    return
+  end
+
+  # Abbreviations are already expanded, but aliases are functions with a
+  # description like "alias g=git"
+  set -l expanded
+  set -l words (string split -m1 ' ' -- "$argv")
+  set -l description (functions --details --verbose -- $words[1] 2>/dev/null)[5]
+  if string match -q 'alias *' -- "$description"
+    set expanded (string replace -r '^alias [^= ]+[= ]' '' -- "$description") $words[2]
+  end
+
+  marblezero --exit-code $exit_code --duration {$CMD_DURATION}ms --cwd $PWD --expanded "$expanded" --import-complete "$argv"
 end
\ No newline at end of file
@@ -7,3 +7,6 @@ var Fish string
 
 //go:embed marblezero.zsh
 var Zsh string
+
+//go:embed marblezero.bash
+var Bash string
@@ -0,0 +1,323 @@
+package sprites
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sturdy-dev/marblezero/cats"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+const (
+	// Dir is where sprite packs are kept in the storage path
+	Dir = "sprites"
+
+	// Builtin is the name of the built-in pack
+	Builtin = "cat"
+
+	// manifestFile describes a pack that is a directory
+	manifestFile = "pack.json"
+
+	// Idle is the animation that every pack must have, and that is shown
+	// for animations that a pack doesn't have
+	Idle = "idle"
+
+	// MaxWidth and MaxHeight are how large a frame can be to fit on the
+	// screen of the device
+	MaxWidth  = 24
+	MaxHeight = 11
+
+	// defaultDuration is how long frames are shown for, unless the frame or
+	// its animation says otherwise
+	defaultDuration = 500 * time.Millisecond
+)
+
+var (
+	namePattern  = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	colorPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3}|#[0-9a-fA-F]{6}|[0-9]{1,3})$`)
+)
+
+// Pack is a set of named animations that the pet is drawn with. A pack is
+// either a directory with a pack.json manifest and the frames in text files
+// next to it, or a single json file with the frames inline:
+//
+//	{
+//	  "name": "duck",
+//	  "author": "Someone",
+//	  "colors": {"y": "#f9cf16"},
+//	  "animations": {
+//	    "idle": {
+//	      "duration": "500ms",
+//	      "frames": [
+//	        {"file": "duck.txt", "mask_file": "duck.mask"},
+//	        {"text": "  __\n<(o )___\n ( ._> /\n  `---'", "duration": "1s"}
+//	      ]
+//	    }
+//	  }
+//	}
+//
+// The animations are named after what the pet is doing: "idle", "sleeping",
+// "excited", "sulking" and "curious". Only "idle" is required, it's shown in
+// place of the others when they are missing.
+//
+// A frame can have a colour mask, which has the same shape as the frame. Each
+// character of the mask that is a key of colors gives the character of the
+// frame at the same position that colour, spaces leave it as it is.
+type Pack struct {
+	Name       string
+	Author     string
+	Animations map[string][]Frame
+}
+
+// Frame is a single frame of an animation
+type Frame struct {
+	Text     string
+	Duration time.Duration
+
+	// mask has the colour of each character of the text, by line
+	mask [][]lipgloss.TerminalColor
+}
+
+type manifest struct {
+	Name       string                       `json:"name"`
+	Author     string                       `json:"author,omitempty"`
+	Colors     map[string]string            `json:"colors,omitempty"`
+	Animations map[string]animationManifest `json:"animations"`
+}
+
+type animationManifest struct {
+	Duration string          `json:"duration,omitempty"`
+	Frames   []frameManifest `json:"frames"`
+}
+
+type frameManifest struct {
+	File     string `json:"file,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	MaskFile string `json:"mask_file,omitempty"`
+	Mask     string `json:"mask,omitempty"`
+}
+
+// Open opens the pack with the name, either the built-in one or one in the
+// sprites directory of the storage path
+func Open(storagePath state.StoragePath, name string) (*Pack, error) {
+	if name == "" || name == Builtin {
+		return Load(cats.FS, ".")
+	}
+	if !namePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid sprite pack name %q, only lowercase letters, digits, - and _ are allowed", name)
+	}
+	return Load(os.DirFS(path.Join(string(storagePath), Dir)), name)
+}
+
+// Load loads and validates the pack with the name from fsys, which is either
+// a directory with a pack.json, or a name.json file. Files are read relative
+// to the directory of the manifest.
+func Load(fsys fs.FS, name string) (*Pack, error) {
+	dir, file := name, path.Join(name, manifestFile)
+	data, err := fs.ReadFile(fsys, file)
+	if errors.Is(err, fs.ErrNotExist) && name != "." {
+		dir, file = path.Dir(name), name+".json"
+		data, err = fs.ReadFile(fsys, file)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("sprite pack %q not found", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sprite pack %q: %w", name, err)
+	}
+
+	var m manifest
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	pack, err := m.pack(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sprite pack %s: %w", file, err)
+	}
+	return pack, nil
+}
+
+func (m manifest) pack(fsys fs.FS, dir string) (*Pack, error) {
+	if m.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	if _, ok := m.Animations[Idle]; !ok {
+		return nil, fmt.Errorf("missing the %q animation", Idle)
+	}
+
+	colors := map[rune]lipgloss.TerminalColor{}
+	for key, color := range m.Colors {
+		r := []rune(key)
+		if len(r) != 1 || r[0] == ' ' {
+			return nil, fmt.Errorf("invalid colour key %q, must be a single character", key)
+		}
+		if !colorPattern.MatchString(color) {
+			return nil, fmt.Errorf("invalid colour %q, must be like #f97316 or an ANSI colour number", color)
+		}
+		colors[r[0]] = lipgloss.Color(color)
+	}
+
+	pack := &Pack{Name: m.Name, Author: m.Author, Animations: map[string][]Frame{}}
+	for name, a := range m.Animations {
+		if len(a.Frames) == 0 {
+			return nil, fmt.Errorf("animation %q has no frames", name)
+		}
+		fallback, err := duration(a.Duration, defaultDuration)
+		if err != nil {
+			return nil, fmt.Errorf("animation %q: %w", name, err)
+		}
+		for i, f := range a.Frames {
+			frame, err := f.frame(fsys, dir, colors, fallback)
+			if err != nil {
+				return nil, fmt.Errorf("frame %d of %q: %w", i+1, name, err)
+			}
+			pack.Animations[name] = append(pack.Animations[name], frame)
+		}
+	}
+	return pack, nil
+}
+
+func (f frameManifest) frame(fsys fs.FS, dir string, colors map[rune]lipgloss.TerminalColor, fallback time.Duration) (Frame, error) {
+	text, err := content(fsys, dir, f.File, f.Text, "file", "text")
+	if err != nil {
+		return Frame{}, err
+	}
+	if text == "" {
+		return Frame{}, errors.New("missing file or text")
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > MaxHeight {
+		return Frame{}, fmt.Errorf("%d lines high, must be at most %d", len(lines), MaxHeight)
+	}
+	for _, line := range lines {
+		if w := lipgloss.Width(line); w > MaxWidth {
+			return Frame{}, fmt.Errorf("%d characters wide, must be at most %d", w, MaxWidth)
+		}
+	}
+
+	frame := Frame{Text: text}
+	if frame.Duration, err = duration(f.Duration, fallback); err != nil {
+		return Frame{}, err
+	}
+
+	mask, err := content(fsys, dir, f.MaskFile, f.Mask, "mask_file", "mask")
+	if err != nil {
+		return Frame{}, err
+	}
+	if mask == "" {
+		return frame, nil
+	}
+	maskLines := strings.Split(mask, "\n")
+	if len(maskLines) > len(lines) {
+		return Frame{}, errors.New("the mask is higher than the frame")
+	}
+	for i, line := range maskLines {
+		width := len([]rune(lines[i]))
+		var row []lipgloss.TerminalColor
+		for j, key := range []rune(line) {
+			if key == ' ' {
+				row = append(row, nil)
+				continue
+			}
+			color, ok := colors[key]
+			if !ok {
+				return Frame{}, fmt.Errorf("unknown colour %q in the mask", key)
+			}
+			if j >= width {
+				return Frame{}, fmt.Errorf("the mask is wider than the frame on line %d", i+1)
+			}
+			row = append(row, color)
+		}
+		frame.mask = append(frame.mask, row)
+	}
+	return frame, nil
+}
+
+// content is either read from the file, or given inline
+func content(fsys fs.FS, dir, file, inline, fileField, inlineField string) (string, error) {
+	if file != "" && inline != "" {
+		return "", fmt.Errorf("only one of %s and %s can be used", fileField, inlineField)
+	}
+	if file == "" {
+		return inline, nil
+	}
+	if path.IsAbs(file) || strings.HasPrefix(path.Clean(file), "..") {
+		return "", fmt.Errorf("%s %q must be inside the pack", fileField, file)
+	}
+	data, err := fs.ReadFile(fsys, path.Join(dir, file))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+func duration(s string, fallback time.Duration) (time.Duration, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q, must be like \"500ms\"", s)
+	}
+	return d, nil
+}
+
+// Frame returns the nth frame of the animation, counting from the start of
+// the animation and looping around. The idle animation is used if the pack
+// doesn't have the animation.
+func (p *Pack) Frame(animation string, n int) Frame {
+	frames, ok := p.Animations[animation]
+	if !ok {
+		frames = p.Animations[Idle]
+	}
+	return frames[n%len(frames)]
+}
+
+// Render renders the frame with the style, giving characters their colour
+// from the mask
+func (f Frame) Render(style lipgloss.Style) string {
+	lines := strings.Split(f.Text, "\n")
+	for i, line := range lines {
+		if i >= len(f.mask) {
+			lines[i] = style.Render(line)
+			continue
+		}
+
+		// characters of the same colour are rendered together
+		var b strings.Builder
+		runes := []rune(line)
+		for start := 0; start < len(runes); {
+			color := f.color(i, start)
+			end := start + 1
+			for end < len(runes) && f.color(i, end) == color {
+				end++
+			}
+			s := style.Copy()
+			if color != nil {
+				s = s.Foreground(color)
+			}
+			b.WriteString(s.Render(string(runes[start:end])))
+			start = end
+		}
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (f Frame) color(line, i int) lipgloss.TerminalColor {
+	if i < len(f.mask[line]) {
+		return f.mask[line][i]
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package sprites
+
+import (
+	"os"
+	"path"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/sturdy-dev/marblezero/pet"
+	"github.com/sturdy-dev/marblezero/state"
+)
+
+func TestBuiltin(t *testing.T) {
+	pack, err := Open("", "")
+	require.NoError(t, err)
+	assert.Equal(t, Builtin, pack.Name)
+
+	// every state of the pet has an animation of its own
+	for _, s := range []pet.State{pet.Idle, pet.Sleeping, pet.Excited, pet.Sulking} {
+		assert.Contains(t, pack.Animations, string(s))
+	}
+	assert.Contains(t, pack.Animations, "curious")
+
+	idle := pack.Animations[Idle]
+	assert.Equal(t, time.Second, idle[0].Duration)
+	assert.Equal(t, defaultDuration, idle[1].Duration)
+	assert.Equal(t, 300*time.Millisecond, pack.Frame("excited", 0).Duration)
+}
+
+func TestLoad(t *testing.T) {
+	duck := "  __\n<(o )___\n ( ._> /\n  `---'"
+	fsys := fstest.MapFS{
+		"dragon/pack.json": {Data: []byte(`{
+			"name": "dragon",
+			"colors": {"r": "#ff0000", "g": "2"},
+			"animations": {
+				"idle": {"duration": "1s", "frames": [
+					{"file": "dragon.txt", "mask_file": "dragon.mask"},
+					{"file": "dragon.txt", "duration": "250ms"}
+				]},
+				"sleeping": {"frames": [{"text": "zzz", "mask": " g"}]}
+			}
+		}`)},
+		"dragon/dragon.txt":  {Data: []byte("<\\_/>\n(o o)~~\n")},
+		"dragon/dragon.mask": {Data: []byte("\n r r\n")},
+		"duck.json":          {Data: []byte(`{"name": "duck", "author": "Someone", "animations": {"idle": {"frames": [{"text": "  __\n<(o )___\n ( ._> /\n  ` + "`---'" + `"}]}}}`)},
+	}
+
+	pack, err := Load(fsys, "dragon")
+	require.NoError(t, err)
+	assert.Equal(t, "dragon", pack.Name)
+	require.Len(t, pack.Animations[Idle], 2)
+
+	frame := pack.Frame(Idle, 0)
+	assert.Equal(t, "<\\_/>\n(o o)~~", frame.Text)
+	assert.Equal(t, time.Second, frame.Duration)
+	assert.Nil(t, frame.color(0, 1))
+	assert.Nil(t, frame.color(1, 0))
+	assert.Equal(t, lipgloss.Color("#ff0000"), frame.color(1, 1))
+	assert.Equal(t, lipgloss.Color("#ff0000"), frame.color(1, 3))
+	assert.Nil(t, frame.color(1, 5), "past the end of the mask")
+	assert.Equal(t, 250*time.Millisecond, pack.Frame(Idle, 1).Duration)
+	assert.Equal(t, pack.Frame(Idle, 0), pack.Frame(Idle, 2), "animations loop")
+	assert.Equal(t, lipgloss.Color("2"), pack.Frame("sleeping", 0).color(0, 1))
+
+	// animations that the pack doesn't have are idle
+	assert.Equal(t, pack.Frame(Idle, 1), pack.Frame("sulking", 1))
+
+	// colours don't change the text
+	assert.Equal(t, "<\\_/>\n(o o)~~", frame.Render(lipgloss.NewStyle()))
+
+	pack, err = Load(fsys, "duck")
+	require.NoError(t, err)
+	assert.Equal(t, "Someone", pack.Author)
+	assert.Equal(t, duck, pack.Frame(Idle, 0).Text)
+	assert.Equal(t, defaultDuration, pack.Frame(Idle, 0).Duration)
+}
+
+func TestLoadErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{"not json", `{`, "failed to parse"},
+		{"unknown field", `{"name": "x", "size": 1, "animations": {"idle": {"frames": [{"text": "x"}]}}}`, `unknown field "size"`},
+		{"no name", `{"animations": {"idle": {"frames": [{"text": "x"}]}}}`, "missing name"},
+		{"no idle", `{"name": "x", "animations": {"sleeping": {"frames": [{"text": "x"}]}}}`, `missing the "idle" animation`},
+		{"no frames", `{"name": "x", "animations": {"idle": {"frames": []}}}`, `animation "idle" has no frames`},
+		{"empty frame", `{"name": "x", "animations": {"idle": {"frames": [{}]}}}`, `frame 1 of "idle": missing file or text`},
+		{"file and text", `{"name": "x", "animations": {"idle": {"frames": [{"text": "x", "file": "x.txt"}]}}}`, "only one of file and text"},
+		{"missing file", `{"name": "x", "animations": {"idle": {"frames": [{"file": "x.txt"}]}}}`, "failed to read x.txt"},
+		{"outside the pack", `{"name": "x", "animations": {"idle": {"frames": [{"file": "../x.txt"}]}}}`, "must be inside the pack"},
+		{"too wide", `{"name": "x", "animations": {"idle": {"frames": [{"text": "0123456789012345678901234"}]}}}`, "25 characters wide, must be at most 24"},
+		{"too high", `{"name": "x", "animations": {"idle": {"frames": [{"text": "\n\n\n\n\n\n\n\n\n\n\n"}]}}}`, "12 lines high, must be at most 11"},
+		{"bad duration", `{"name": "x", "animations": {"idle": {"frames": [{"text": "x", "duration": "fast"}]}}}`, `invalid duration "fast"`},
+		{"bad animation duration", `{"name": "x", "animations": {"idle": {"duration": "-1s", "frames": [{"text": "x"}]}}}`, `animation "idle": invalid duration "-1s"`},
+		{"bad colour key", `{"name": "x", "colors": {"rr": "#fff"}, "animations": {"idle": {"frames": [{"text": "x"}]}}}`, `invalid colour key "rr"`},
+		{"bad colour", `{"name": "x", "colors": {"r": "red"}, "animations": {"idle": {"frames": [{"text": "x"}]}}}`, `invalid colour "red"`},
+		{"unknown colour", `{"name": "x", "animations": {"idle": {"frames": [{"text": "x", "mask": "r"}]}}}`, `unknown colour 'r' in the mask`},
+		{"mask too wide", `{"name": "x", "colors": {"r": "1"}, "animations": {"idle": {"frames": [{"text": "x", "mask": " r"}]}}}`, "the mask is wider than the frame on line 1"},
+		{"mask too high", `{"name": "x", "colors": {"r": "1"}, "animations": {"idle": {"frames": [{"text": "x", "mask": "r\nr"}]}}}`, "the mask is higher than the frame"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(fstest.MapFS{"x.json": {Data: []byte(tc.manifest)}}, "x")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.err)
+		})
+	}
+
+	_, err := Load(fstest.MapFS{}, "x")
+	assert.EqualError(t, err, `sprite pack "x" not found`)
+}
+
+func TestOpen(t *testing.T) {
+	storagePath := state.StoragePath(t.TempDir())
+	dir := path.Join(string(storagePath), Dir, "duck")
+	require.NoError(t, os.MkdirAll(dir, 0777))
+	require.NoError(t, os.WriteFile(path.Join(dir, "pack.json"), []byte(`{"name": "duck", "animations": {"idle": {"frames": [{"file": "duck.txt"}]}}}`), 0666))
+	require.NoError(t, os.WriteFile(path.Join(dir, "duck.txt"), []byte("<(o )___\n"), 0666))
+
+	pack, err := Open(storagePath, "duck")
+	require.NoError(t, err)
+	assert.Equal(t, "<(o )___", pack.Frame(Idle, 0).Text)
+
+	pack, err = Open(storagePath, Builtin)
+	require.NoError(t, err)
+	assert.Equal(t, Builtin, pack.Name)
+
+	_, err = Open(storagePath, "../duck")
+	assert.ErrorContains(t, err, "invalid sprite pack name")
+	_, err = Open(storagePath, "dog")
+	assert.EqualError(t, err, `sprite pack "dog" not found`)
+}
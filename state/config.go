@@ -11,10 +11,63 @@ import (
 type Config struct {
 	Name string `json:"name"`
 
+	// Aliases are expanded when importing commands, for shells that can't
+	// expand aliases themselves. For example {"k": "kubectl"}.
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	// Commands extends and overrides what is tracked for each program, see Command
+	Commands map[string]Command `json:"commands,omitempty"`
+
+	// Ignore describes commands that are never imported
+	Ignore Ignore `json:"ignore"`
+
+	// Timezone is used to tell which day commands were run on for streaks,
+	// like "Europe/Stockholm". Defaults to the local time zone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// DayRollover is the hour the day starts at for streaks, so that night
+	// owls can keep them going after midnight
+	DayRollover int `json:"day_rollover,omitempty"`
+
+	// Sprites is the sprite pack the pet is drawn with, from the sprites
+	// directory in the storage path. Defaults to the built-in cat.
+	Sprites string `json:"sprites,omitempty"`
+
 	// self saveable
 	storagePath StoragePath `json:"-"`
 }
 
+type Ignore struct {
+	// Commands are programs that are never imported, like "pass"
+	Commands []string `json:"commands,omitempty"`
+
+	// Patterns are regular expressions, lines matching any of them are never imported
+	Patterns []string `json:"patterns,omitempty"`
+
+	// LeadingSpace ignores lines starting with a space, like HISTCONTROL=ignorespace
+	// in bash. Defaults to true.
+	LeadingSpace *bool `json:"leading_space,omitempty"`
+}
+
+// Command describes what is tracked when a program is used. Nothing but the
+// name of the program is tracked for programs without a Command.
+type Command struct {
+	// Subcommands is the number of subcommands to track, for example 1 for
+	// "git commit" and 2 for "aws s3 cp"
+	Subcommands int `json:"subcommands,omitempty"`
+
+	// Nested describes subcommands with subcommands of their own, like the
+	// "compose" in "docker compose up"
+	Nested map[string]Command `json:"nested,omitempty"`
+
+	// Flags enables tracking of flags, like "--force"
+	Flags bool `json:"flags,omitempty"`
+
+	// ValueFlags are flags that take a value as the next argument, like
+	// "-n" in "kubectl -n default get pods"
+	ValueFlags []string `json:"value_flags,omitempty"`
+}
+
 type StoragePath string
 
 func NewStoragePath() (StoragePath, error) {
@@ -0,0 +1,42 @@
+package state
+
+import (
+	"errors"
+	"os"
+)
+
+// OpenLocked opens and locks a file that might be replaced while waiting for
+// the lock, like the wal when it's compacted. If the file was replaced, the new
+// file is opened and locked instead.
+func OpenLocked(name string, flag int, perm os.FileMode, exclusive bool) (*os.File, error) {
+	lock := LockShared
+	if exclusive {
+		lock = LockExclusive
+	}
+
+	for {
+		fp, err := os.OpenFile(name, flag, perm)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := lock(fp); err != nil {
+			fp.Close()
+			return nil, err
+		}
+
+		opened, err := fp.Stat()
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		current, err := os.Stat(name)
+		if err == nil && os.SameFile(opened, current) {
+			return fp, nil
+		}
+		fp.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
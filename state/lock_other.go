@@ -0,0 +1,20 @@
+//go:build !unix
+
+package state
+
+import "os"
+
+// LockShared is a no-op on platforms without flock
+func LockShared(fp *os.File) error {
+	return nil
+}
+
+// LockExclusive is a no-op on platforms without flock
+func LockExclusive(fp *os.File) error {
+	return nil
+}
+
+// Unlock is a no-op on platforms without flock
+func Unlock(fp *os.File) error {
+	return nil
+}
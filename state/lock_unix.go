@@ -0,0 +1,40 @@
+//go:build unix
+
+package state
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// LockShared takes an advisory shared lock on the file, blocking while anyone
+// else holds an exclusive lock. Used when reading the wal.
+func LockShared(fp *os.File) error {
+	return flock(fp, syscall.LOCK_SH)
+}
+
+// LockExclusive takes an advisory exclusive lock on the file, blocking while
+// anyone else holds a lock. Used when writing to the wal.
+func LockExclusive(fp *os.File) error {
+	return flock(fp, syscall.LOCK_EX)
+}
+
+// Unlock releases a lock taken with LockShared or LockExclusive. Closing the
+// file releases it as well.
+func Unlock(fp *os.File) error {
+	return flock(fp, syscall.LOCK_UN)
+}
+
+func flock(fp *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(fp.Fd()), how)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", fp.Name(), err)
+		}
+		return nil
+	}
+}